@@ -2,8 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/go-kit/kit/log"
@@ -12,9 +16,19 @@ import (
 	app "github.com/zt-sv/grafana-annotations-bot/internal/app/grafana-annotations-bot"
 	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/database"
 	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/grafana"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/health"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/retry"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/server"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/source"
 	tg "github.com/zt-sv/grafana-annotations-bot/internal/pkg/telegram"
 )
 
+const (
+	// Liveness check fails when the annotations listener has not made progress for this long
+	livenessGracePeriod = time.Minute
+	httpShutdownTimeout = 5 * time.Second
+)
+
 func main() {
 	var gr run.Group
 	config, err := app.LoadConfig()
@@ -26,110 +40,310 @@ func main() {
 
 	var logger = app.GetLogger(config)
 
-	kvStore, err := database.NewDB(
-		config.StorageConfig,
-		log.With(logger, "component", "database"),
-	)
+	// Dependencies may be temporarily down, e.g. during upgrades, so retry them until the startup deadline.
+	// Each dependency has its own deadline, a long outage of one does not leave the next ones a single attempt.
+	storeCtx, storeCancel := context.WithTimeout(context.Background(), config.StartupTimeout)
+	defer storeCancel()
+
+	var kvStore *database.DbClient
+
+	err = retry.Do(storeCtx, retry.DefaultBackoff, func() error {
+		var err error
+		kvStore, err = database.NewDB(
+			config.StorageConfig,
+			log.With(logger, "component", "database"),
+		)
+
+		if err != nil {
+			return err
+		}
+
+		if err = kvStore.Ping(storeCtx); err != nil {
+			kvStore.Close()
+		}
+
+		return err
+	}, func(err error, delay time.Duration) {
+		level.Warn(logger).Log("msg", "store is unavailable, retry", "err", err, "retry_in", delay)
+	})
 
 	if err != nil {
 		level.Error(logger).Log("msg", "failed to create store client", "err", err)
 		os.Exit(1)
 	}
 
-	// Create Grafana client
-	grafanaClient, err := grafana.NewClient(
-		grafana.ClientConfig{
-			URL:         config.GrafanaConfig.URL,
-			Token:       config.GrafanaConfig.Token,
-			TLSInsecure: config.GrafanaConfig.TLSInsecure,
-			SkipVerify:  config.GrafanaConfig.TLSInsecureSkipVerify,
-			CertFile:    config.GrafanaConfig.TLSCert,
-			KeyFile:     config.GrafanaConfig.TLSKey,
-			Logger:      log.With(logger, "component", "grafana_client"),
-		},
+	// Create Grafana clients and annotation sources
+	var (
+		grafanaInstances []tg.GrafanaInstance
+		sources          []source.Source
 	)
 
-	if err != nil {
-		level.Error(logger).Log("msg", "failed to create grafana client", "err", err)
-		os.Exit(1)
+	grafanaClients := make([]*grafana.Client, len(config.GrafanaInstances))
+
+	for i, instanceConfig := range config.GrafanaInstances {
+		instanceLogger := log.With(logger, "grafana", instanceConfig.Name)
+		grafanaClients[i], err = grafana.NewClient(
+			grafana.ClientConfig{
+				Name:              instanceConfig.Name,
+				URL:               instanceConfig.URL,
+				Token:             instanceConfig.Token,
+				TokenFile:         instanceConfig.TokenFile,
+				BasicAuthUsername: instanceConfig.BasicAuthUsername,
+				BasicAuthPassword: instanceConfig.BasicAuthPassword,
+				Headers:           instanceConfig.Headers,
+				TLSInsecure:       instanceConfig.TLSInsecure,
+				SkipVerify:        instanceConfig.TLSInsecureSkipVerify,
+				CAFile:            instanceConfig.TLSCA,
+				CertFile:          instanceConfig.TLSCert,
+				KeyFile:           instanceConfig.TLSKey,
+				Timeout:           instanceConfig.Timeout,
+				Logger:            log.With(instanceLogger, "component", "grafana_client"),
+			},
+		)
+
+		if err != nil {
+			level.Error(instanceLogger).Log("msg", "failed to create grafana client", "err", err)
+			os.Exit(1)
+		}
 	}
 
-	// Check grafana status
-	grafanaStatus, err := grafanaClient.GetStatus()
+	// Check grafana status of the instances in parallel, an unreachable instance does not delay the others
+	grafanaErrs := make([]error, len(grafanaClients))
+	var grafanaWg sync.WaitGroup
 
-	if err != nil {
-		level.Error(logger).Log("msg", "failed to get grafana status", "err", err)
-		os.Exit(1)
+	for i, grafanaClient := range grafanaClients {
+		grafanaWg.Add(1)
+
+		go func(i int, grafanaClient *grafana.Client) {
+			defer grafanaWg.Done()
+			grafanaErrs[i] = checkGrafana(grafanaClient, config.StartupTimeout, log.With(logger, "grafana", grafanaClient.Name()))
+		}(i, grafanaClient)
 	}
 
-	level.Info(logger).Log(
-		"msg", "grafana status",
-		"grafana_version", grafanaStatus.Version,
-		"database", grafanaStatus.Database,
-		"commit", grafanaStatus.Commit,
-	)
+	grafanaWg.Wait()
+
+	for i, instanceConfig := range config.GrafanaInstances {
+		instanceLogger := log.With(logger, "grafana", instanceConfig.Name)
+		grafanaState := health.NewDependency()
+
+		if err := grafanaErrs[i]; err != nil {
+			if !config.StartupDegraded {
+				level.Error(instanceLogger).Log("msg", "failed to get grafana status", "err", err)
+				os.Exit(1)
+			}
+
+			grafanaState.Fail(err)
+			level.Warn(instanceLogger).Log("msg", "grafana is unavailable, start in degraded mode", "err", err)
+		}
+
+		grafanaInstances = append(grafanaInstances, tg.GrafanaInstance{
+			Name:   instanceConfig.Name,
+			Client: grafanaClients[i],
+			State:  grafanaState,
+			OrgIDs: instanceConfig.OrgIDs,
+		})
+		sources = append(sources, source.NewGrafanaPoller(source.GrafanaPollerConfig{
+			Client:   grafanaClients[i],
+			OrgIDs:   instanceConfig.OrgIDs,
+			Interval: instanceConfig.ScrapeInterval,
+			State:    grafanaState,
+			Cursors:  kvStore,
+			Logger:   log.With(instanceLogger, "component", "grafana_poller"),
+		}))
+	}
+
+	// Create webhook receiver
+	var webhook *source.Webhook
+
+	if config.WebhookConfig.Token != "" || config.WebhookConfig.HMACSecret != "" {
+		webhook, err = source.NewWebhook(source.WebhookConfig{
+			Path:       config.WebhookConfig.Path,
+			Token:      config.WebhookConfig.Token,
+			HMACSecret: config.WebhookConfig.HMACSecret,
+			Logger:     log.With(logger, "component", "webhook"),
+		})
+
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to create webhook", "err", err)
+			os.Exit(1)
+		}
+
+		sources = append(sources, webhook)
+	}
 
 	// Create telegram bot
-	tgBot, err := tg.NewBot(
-		tg.BotOptions{
-			Token:         config.TelegramToken,
-			Store:         kvStore,
-			Logger:        log.With(logger, "component", "telegram_bot"),
-			Template:      config.Template,
-			GrafanaClient: grafanaClient,
-			Admins:        config.TelegramAdmins,
-		},
-	)
+	var tgBot *tg.Bot
+
+	telegramCtx, telegramCancel := context.WithTimeout(context.Background(), config.StartupTimeout)
+	defer telegramCancel()
+
+	err = retry.Do(telegramCtx, retry.DefaultBackoff, func() error {
+		var err error
+		tgBot, err = tg.NewBot(
+			tg.BotOptions{
+				Token:         config.TelegramToken,
+				Store:         kvStore,
+				Logger:        log.With(logger, "component", "telegram_bot"),
+				Template:      config.Template,
+				Templates:     config.Templates,
+				ListTemplate:  config.ListTemplate,
+				StatsTemplate: config.StatsTemplate,
+				Grafana:       grafanaInstances,
+				Admins:        config.TelegramAdmins,
+				Alerting: tg.AlertingOptions{
+					ChatID:            config.AlertingConfig.ChatID,
+					ScrapeFailures:    config.AlertingConfig.ScrapeFailures,
+					StoreFailures:     config.AlertingConfig.StoreFailures,
+					DeliveryErrorRate: config.AlertingConfig.DeliveryErrorRate,
+					Interval:          config.AlertingConfig.Interval,
+					MinInterval:       config.AlertingConfig.MinInterval,
+					RepeatInterval:    config.AlertingConfig.RepeatInterval,
+				},
+				Reports: tg.ReportOptions{
+					Chats:   config.ReportsConfig.Chats,
+					Weekday: config.ReportsConfig.Weekday,
+					At:      config.ReportsConfig.At,
+					GroupBy: config.ReportsConfig.GroupBy,
+				},
+				Subscriptions: tg.SubscriptionOptions{
+					DeadAfter: config.SubscriptionsConfig.DeadAfter,
+					Notify:    config.SubscriptionsConfig.Notify,
+				},
+				Reload: tg.ReloadOptions{
+					TemplatePath: config.TemplatePath,
+					TemplatesDir: config.TemplatesDir,
+					Interval:     config.TemplateReload,
+				},
+			},
+		)
+		return err
+	}, func(err error, delay time.Duration) {
+		level.Warn(logger).Log("msg", "telegram is unavailable, retry", "err", err, "retry_in", delay)
+	})
 
 	if err != nil {
 		level.Error(logger).Log("msg", "failed to start telegram bot", "err", err)
 		os.Exit(1)
 	}
 
+	storeCancel()
+	telegramCancel()
+
 	ctx, cancel := context.WithCancel(context.Background())
 	annotationsChannel := make(chan grafana.Annotation, 32)
-	ticker := time.NewTicker(config.GrafanaConfig.ScrapeInterval)
-	lastScrapeTime := time.Now()
-	quit := make(chan struct{})
 
-	// Start bot goroutine
+	// Health checks
+	healthChecker := health.NewChecker(log.With(logger, "component", "health"))
+	healthChecker.AddLivenessCheck("listener", tgBot.Heartbeat().Check(livenessGracePeriod))
+	healthChecker.AddReadinessCheck("store", kvStore.Ping)
+	healthChecker.AddReadinessCheck("telegram", tgBot.Ping)
+
+	httpServer := server.NewServer(config.WebListenAddress, log.With(logger, "component", "http_server"))
+	httpServer.Handle(health.LivenessPath, healthChecker.LivenessHandler())
+	httpServer.Handle(health.ReadinessPath, healthChecker.ReadinessHandler())
+
+	if webhook != nil {
+		httpServer.Handle(webhook.Path(), webhook)
+	}
+
+	// Internal HTTP server goroutine
 	gr.Add(func() error {
-		return tgBot.Run(ctx, annotationsChannel)
+		return httpServer.Run()
 	}, func(err error) {
-		cancel()
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer shutdownCancel()
+		httpServer.Shutdown(shutdownCtx)
 	})
 
-	// Scrape grafana goroutine
-	gr.Add(func() error {
-		for {
-			select {
-			case <-ticker.C:
-				currTime := time.Now()
-				annotationsResps, err := grafanaClient.GetAnnotations(lastScrapeTime, currTime)
-
-				if err != nil {
-					level.Error(logger).Log("msg", "failed to get annotations", "err", err)
-				}
+	// Signal handler goroutine
+	gr.Add(run.SignalHandler(context.Background(), syscall.SIGINT, syscall.SIGTERM))
 
-				lastScrapeTime = currTime
+	// Templates reload goroutine
+	{
+		reload := make(chan os.Signal, 1)
+		stop := make(chan struct{})
+		signal.Notify(reload, syscall.SIGHUP)
 
-				for i := len(annotationsResps) - 1; i >= 0; i-- {
-					level.Info(logger).Log("msg", "get new annotation", "annotation", annotationsResps[i])
-					annotationsChannel <- annotationsResps[i]
+		gr.Add(func() error {
+			for {
+				select {
+				case <-stop:
+					return nil
+				case <-reload:
+					tgBot.ReloadTemplates("SIGHUP")
 				}
-
-			case <-quit:
-				ticker.Stop()
-				return nil
 			}
-		}
+		}, func(err error) {
+			signal.Stop(reload)
+			close(stop)
+		})
+	}
+
+	// Start bot goroutine, it drains annotations channel until all the sources stop
+	gr.Add(func() error {
+		return tgBot.Run(ctx, annotationsChannel)
 	}, func(err error) {
-		cancel()
+		drainTimer := time.AfterFunc(config.ShutdownTimeout, func() {
+			level.Warn(logger).Log("msg", "shutdown timeout exceeded, abort annotations delivery")
+			cancel()
+		})
+		go func() {
+			<-ctx.Done()
+			drainTimer.Stop()
+		}()
 	})
 
+	// Annotation sources goroutines, the annotations channel is closed when all of them stop
+	for _, src := range sources {
+		healthChecker.AddLivenessCheck("source/"+src.Name(), src.Alive)
+		healthChecker.AddReadinessCheck("source/"+src.Name(), src.Ready)
+	}
+
+	source.AddToGroup(&gr, ctx, sources, annotationsChannel)
+
 	// Start
-	if err := gr.Run(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
+	err = gr.Run()
+
+	cancel()
+
+	if closeErr := kvStore.Close(); closeErr != nil {
+		level.Error(logger).Log("msg", "failed to close store", "err", closeErr)
+	}
+
+	var signalErr run.SignalError
+
+	if errors.As(err, &signalErr) {
+		level.Info(logger).Log("msg", "shutdown complete", "signal", signalErr.Signal)
+		return
+	}
+
+	if err != nil {
+		level.Error(logger).Log("msg", "shutdown after failure", "err", err)
 		os.Exit(1)
 	}
 }
+
+// checkGrafana : retry Grafana status until it succeeds or the timeout expires
+func checkGrafana(grafanaClient *grafana.Client, timeout time.Duration, logger log.Logger) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return retry.Do(ctx, retry.DefaultBackoff, func() error {
+		grafanaStatus, err := grafanaClient.GetStatus(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		level.Info(logger).Log(
+			"msg", "grafana status",
+			"grafana_version", grafanaStatus.Version,
+			"database", grafanaStatus.Database,
+			"commit", grafanaStatus.Commit,
+		)
+
+		return nil
+	}, func(err error, delay time.Duration) {
+		level.Warn(logger).Log("msg", "grafana is unavailable, retry", "err", err, "retry_in", delay)
+	})
+}
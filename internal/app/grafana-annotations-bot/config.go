@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	kingpin "github.com/alecthomas/kingpin/v2"
@@ -23,11 +24,24 @@ const (
 	StoreTypeEtcdV3 = "etcdv3"
 
 	storeKeyPrefix = "annotationsbot/chats"
+	stateKeyPrefix = "annotationsbot/state"
 
 	levelDebug = "debug"
 	levelInfo  = "info"
 	levelWarn  = "warn"
 	levelError = "error"
+
+	// GroupByTag : group statistics by annotation tags
+	GroupByTag = "tag"
+	// GroupByDashboard : group statistics by dashboards
+	GroupByDashboard = "dashboard"
+	// GroupByUser : group statistics by annotation authors
+	GroupByUser = "user"
+
+	// NotifyAdmins : report dead subscriptions to admins
+	NotifyAdmins = "admins"
+	// NotifyChat : report dead subscription to the subscribed chat
+	NotifyChat = "chat"
 )
 
 type boltdbStoreConfig struct {
@@ -43,13 +57,51 @@ type etcdStoreConfig struct {
 	TLSCA                 string
 }
 
+type alertingConfig struct {
+	ChatID            int64
+	ScrapeFailures    int
+	StoreFailures     int
+	DeliveryErrorRate float64
+	Interval          time.Duration
+	MinInterval       time.Duration
+	RepeatInterval    time.Duration
+}
+
+type reportsConfig struct {
+	Chats       []int64
+	WeekdayName string
+	Weekday     time.Weekday
+	Time        string
+	At          time.Duration
+	GroupBy     string
+}
+
+type subscriptionsConfig struct {
+	DeadAfter time.Duration
+	Notify    string
+}
+
+type webhookConfig struct {
+	Path       string
+	Token      string
+	HMACSecret string
+}
+
 type grafanaConfig struct {
+	Name                  string
 	URL                   *url.URL
 	Token                 string
+	TokenFile             string
+	BasicAuthUsername     string
+	BasicAuthPassword     string
+	Headers               map[string]string
+	OrgIDs                []int64
 	TLSInsecure           bool
 	TLSInsecureSkipVerify bool
 	TLSCert               string
 	TLSKey                string
+	TLSCA                 string
+	Timeout               time.Duration
 	ScrapeInterval        time.Duration
 }
 
@@ -57,20 +109,38 @@ type grafanaConfig struct {
 type StorageConfig struct {
 	StoreType         string
 	StoreKeyPrefix    string
+	StateKeyPrefix    string
 	BoltdbStoreConfig boltdbStoreConfig
 	EtcdStoreConfig   etcdStoreConfig
 }
 
 // Configuration : main project configuration
 type Configuration = struct {
-	GrafanaConfig  grafanaConfig
-	StorageConfig  StorageConfig
-	LogLevel       string
-	LogJSON        bool
-	TelegramAdmins []int64
-	TelegramToken  string
-	TemplatePath   string
-	Template       *template.Template
+	GrafanaConfig        grafanaConfig
+	GrafanaInstances     []grafanaConfig
+	GrafanaInstancesFile string
+	StorageConfig        StorageConfig
+	LogLevel             string
+	LogJSON              bool
+	TelegramAdmins       []int64
+	TelegramToken        string
+	TemplatePath         string
+	Template             TemplateExecutor
+	TemplatesDir         string
+	TemplateReload       time.Duration
+	Templates            map[string]MessageTemplate
+	ListTemplatePath     string
+	ListTemplate         *template.Template
+	StatsTemplatePath    string
+	StatsTemplate        *template.Template
+	WebListenAddress     string
+	ShutdownTimeout      time.Duration
+	StartupTimeout       time.Duration
+	StartupDegraded      bool
+	AlertingConfig       alertingConfig
+	WebhookConfig        webhookConfig
+	ReportsConfig        reportsConfig
+	SubscriptionsConfig  subscriptionsConfig
 }
 
 // LoadConfig : load application config
@@ -82,15 +152,37 @@ func LoadConfig() (Configuration, error) {
 	a.HelpFlag.Short('h')
 
 	a.Flag("grafana.url", "The URL that's used to connect to the Grafana").
-		Required().
 		Envar("GRAFANA_URL").
 		URLVar(&config.GrafanaConfig.URL)
 
+	a.Flag("grafana.instances", "The path to JSON file with the list of Grafana instances, replaces other grafana.* flags except durations").
+		Envar("GRAFANA_INSTANCES_FILE").
+		ExistingFileVar(&config.GrafanaInstancesFile)
+
 	a.Flag("grafana.token", "The Bearer token used to connect with Grafana API").
-		Required().
 		Envar("GRAFANA_TOKEN").
 		StringVar(&config.GrafanaConfig.Token)
 
+	a.Flag("grafana.tokenFile", "The file with Bearer token used to connect with Grafana API, re-read when changed").
+		Envar("GRAFANA_TOKEN_FILE").
+		ExistingFileVar(&config.GrafanaConfig.TokenFile)
+
+	a.Flag("grafana.basicAuth.username", "The username used to connect with Grafana API").
+		Envar("GRAFANA_BASIC_AUTH_USERNAME").
+		StringVar(&config.GrafanaConfig.BasicAuthUsername)
+
+	a.Flag("grafana.basicAuth.password", "The password used to connect with Grafana API").
+		Envar("GRAFANA_BASIC_AUTH_PASSWORD").
+		StringVar(&config.GrafanaConfig.BasicAuthPassword)
+
+	a.Flag("grafana.header", "Extra header for Grafana API requests, e.g. for auth proxy, KEY=VALUE").
+		Envar("GRAFANA_HEADERS").
+		StringMapVar(&config.GrafanaConfig.Headers)
+
+	a.Flag("grafana.orgId", "Grafana organization ID to scrape annotations from, user's default organization when not set").
+		Envar("GRAFANA_ORG_ID").
+		Int64ListVar(&config.GrafanaConfig.OrgIDs)
+
 	a.Flag("grafana.scrapeInterval", "Scrape annotations interval").
 		Envar("GRAFANA_SCRAPE_INTERVAL").
 		Default("10s").
@@ -114,6 +206,15 @@ func LoadConfig() (Configuration, error) {
 		Envar("GRAFANA_TLS_KEY_FILE").
 		ExistingFileVar(&config.GrafanaConfig.TLSKey)
 
+	a.Flag("grafana.tls.ca", "Grafana TLS config - CA file path").
+		Envar("GRAFANA_TLS_CA_FILE").
+		ExistingFileVar(&config.GrafanaConfig.TLSCA)
+
+	a.Flag("grafana.timeout", "Grafana API request timeout").
+		Envar("GRAFANA_TIMEOUT").
+		Default("30s").
+		DurationVar(&config.GrafanaConfig.Timeout)
+
 	a.Flag("store.type", fmt.Sprintf("The store to use. Possible values %s, %s. %s", StoreTypeBolt, StoreTypeEtcdV2, StoreTypeEtcdV3)).
 		Envar("STORE_TYPE").
 		Default(StoreTypeBolt).
@@ -124,6 +225,11 @@ func LoadConfig() (Configuration, error) {
 		Default(storeKeyPrefix).
 		StringVar(&config.StorageConfig.StoreKeyPrefix)
 
+	a.Flag("store.stateKeyPrefix", "Prefix for store keys of bot state, e.g. annotation source cursors").
+		Envar("STORE_STATE_KEY_PREFIX").
+		Default(stateKeyPrefix).
+		StringVar(&config.StorageConfig.StateKeyPrefix)
+
 	a.Flag("bolt.path", "The path to the file where bolt persists its data").
 		Default("/tmp/bot.db").
 		Envar("BOLT_PATH").
@@ -166,6 +272,102 @@ func LoadConfig() (Configuration, error) {
 		Default(levelInfo).
 		EnumVar(&config.LogLevel, levelError, levelWarn, levelInfo, levelDebug)
 
+	a.Flag("web.listenAddress", "Address to listen on for health checks and admin endpoints").
+		Envar("WEB_LISTEN_ADDRESS").
+		Default(":8080").
+		StringVar(&config.WebListenAddress)
+
+	a.Flag("alerting.chat", "Chat ID for pipeline alerts, admins get them in private chats by default").
+		Envar("ALERTING_CHAT").
+		Int64Var(&config.AlertingConfig.ChatID)
+
+	a.Flag("alerting.scrapeFailures", "Consecutive Grafana scrape failures to alert on, 0 disables").
+		Envar("ALERTING_SCRAPE_FAILURES").
+		Default("5").
+		IntVar(&config.AlertingConfig.ScrapeFailures)
+
+	a.Flag("alerting.storeFailures", "Consecutive store failures to alert on, 0 disables").
+		Envar("ALERTING_STORE_FAILURES").
+		Default("3").
+		IntVar(&config.AlertingConfig.StoreFailures)
+
+	a.Flag("alerting.deliveryErrorRate", "Share of failed Telegram messages per check interval to alert on, 0 disables").
+		Envar("ALERTING_DELIVERY_ERROR_RATE").
+		Default("0.5").
+		Float64Var(&config.AlertingConfig.DeliveryErrorRate)
+
+	a.Flag("alerting.interval", "Pipeline check interval").
+		Envar("ALERTING_INTERVAL").
+		Default("1m").
+		DurationVar(&config.AlertingConfig.Interval)
+
+	a.Flag("alerting.minInterval", "Minimal interval between alert and recovery messages about the same problem").
+		Envar("ALERTING_MIN_INTERVAL").
+		Default("5m").
+		DurationVar(&config.AlertingConfig.MinInterval)
+
+	a.Flag("alerting.repeatInterval", "Interval to repeat alert while the problem persists").
+		Envar("ALERTING_REPEAT_INTERVAL").
+		Default("4h").
+		DurationVar(&config.AlertingConfig.RepeatInterval)
+
+	a.Flag("reports.chat", "Chat ID for weekly statistics reports, may be repeated").
+		Envar("REPORTS_CHAT").
+		Int64ListVar(&config.ReportsConfig.Chats)
+
+	a.Flag("reports.weekday", "Day of the week to send statistics reports on").
+		Envar("REPORTS_WEEKDAY").
+		Default("monday").
+		EnumVar(&config.ReportsConfig.WeekdayName, weekdayNames()...)
+
+	a.Flag("reports.time", "Local time of the day to send statistics reports at, HH:MM").
+		Envar("REPORTS_TIME").
+		Default("09:00").
+		StringVar(&config.ReportsConfig.Time)
+
+	a.Flag("reports.groupBy", "Statistics reports grouping").
+		Envar("REPORTS_GROUP_BY").
+		Default(GroupByTag).
+		EnumVar(&config.ReportsConfig.GroupBy, GroupByTag, GroupByDashboard, GroupByUser)
+
+	a.Flag("subscriptions.deadAfter", "Report subscriptions without matched annotations for this long, 0 disables").
+		Envar("SUBSCRIPTIONS_DEAD_AFTER").
+		Default("0").
+		DurationVar(&config.SubscriptionsConfig.DeadAfter)
+
+	a.Flag("subscriptions.notify", "Whom to report dead subscriptions to").
+		Envar("SUBSCRIPTIONS_NOTIFY").
+		Default(NotifyAdmins).
+		EnumVar(&config.SubscriptionsConfig.Notify, NotifyAdmins, NotifyChat)
+
+	a.Flag("startup.timeout", "Time to retry each of unavailable Grafana, store and Telegram on startup").
+		Envar("STARTUP_TIMEOUT").
+		Default("1m").
+		DurationVar(&config.StartupTimeout)
+
+	a.Flag("startup.degraded", "Start in degraded mode when Grafana is still unavailable after startup timeout").
+		Envar("STARTUP_DEGRADED").
+		Default("true").
+		BoolVar(&config.StartupDegraded)
+
+	a.Flag("webhook.path", "Path prefix of webhook endpoints on the web server").
+		Envar("WEBHOOK_PATH").
+		Default("/webhook").
+		StringVar(&config.WebhookConfig.Path)
+
+	a.Flag("webhook.token", "Bearer token for webhook requests, enables webhook").
+		Envar("WEBHOOK_TOKEN").
+		StringVar(&config.WebhookConfig.Token)
+
+	a.Flag("webhook.hmacSecret", "HMAC secret for webhook request signatures, enables webhook").
+		Envar("WEBHOOK_HMAC_SECRET").
+		StringVar(&config.WebhookConfig.HMACSecret)
+
+	a.Flag("shutdown.timeout", "Time to wait for pending annotations delivery on shutdown").
+		Envar("SHUTDOWN_TIMEOUT").
+		Default("30s").
+		DurationVar(&config.ShutdownTimeout)
+
 	a.Flag("telegram.token", "The token used to connect with Telegram").
 		Required().
 		Envar("TELEGRAM_TOKEN").
@@ -176,6 +378,23 @@ func LoadConfig() (Configuration, error) {
 		Envar("TEMPLATE_PATH").
 		ExistingFileVar(&config.TemplatePath)
 
+	a.Flag("template.dir", "The directory with named message templates, *.tmpl files").
+		Envar("TEMPLATE_DIR").
+		ExistingDirVar(&config.TemplatesDir)
+
+	a.Flag("template.reloadInterval", "How often message template files are checked for changes, 0 disables").
+		Envar("TEMPLATE_RELOAD_INTERVAL").
+		Default("10s").
+		DurationVar(&config.TemplateReload)
+
+	a.Flag("template.listPath", "The path to the template of annotation lists, e.g. /last and /history").
+		Envar("TEMPLATE_LIST_PATH").
+		ExistingFileVar(&config.ListTemplatePath)
+
+	a.Flag("template.statsPath", "The path to the template of statistics, e.g. /stats and reports").
+		Envar("TEMPLATE_STATS_PATH").
+		ExistingFileVar(&config.StatsTemplatePath)
+
 	a.Flag("telegram.admin", "The Telegram Admin ID").
 		Required().
 		Envar("TELEGRAM_ADMIN").
@@ -187,14 +406,115 @@ func LoadConfig() (Configuration, error) {
 		return config, err
 	}
 
-	// Check template
-	tpl, err := template.ParseFiles(config.TemplatePath)
+	if strings.HasPrefix(config.StorageConfig.StateKeyPrefix, config.StorageConfig.StoreKeyPrefix) ||
+		strings.HasPrefix(config.StorageConfig.StoreKeyPrefix, config.StorageConfig.StateKeyPrefix) {
+		return config, fmt.Errorf("--store.keyPrefix and --store.stateKeyPrefix must not be prefixes of each other")
+	}
+
+	if config.ReportsConfig.At, err = parseTimeOfDay(config.ReportsConfig.Time); err != nil {
+		return config, fmt.Errorf("invalid --reports.time: %w", err)
+	}
+
+	config.ReportsConfig.Weekday = parseWeekday(config.ReportsConfig.WeekdayName)
+
+	if config.GrafanaInstancesFile != "" {
+		config.GrafanaInstances, err = loadGrafanaInstances(config.GrafanaInstancesFile, config.GrafanaConfig)
+
+		if err != nil {
+			return config, err
+		}
+	} else {
+		if config.GrafanaConfig.URL == nil {
+			return config, fmt.Errorf("one of --grafana.url or --grafana.instances is required")
+		}
+
+		if err = checkGrafanaAuth(config.GrafanaConfig); err != nil {
+			return config, err
+		}
+
+		if err = checkGrafanaDurations(config.GrafanaConfig); err != nil {
+			return config, fmt.Errorf("grafana instance %s: %w", DefaultGrafanaInstance, err)
+		}
+
+		config.GrafanaConfig.Name = DefaultGrafanaInstance
+		config.GrafanaInstances = []grafanaConfig{config.GrafanaConfig}
+	}
+
+	// Check templates
+	config.Templates, err = LoadMessageTemplates(config.TemplatePath, config.TemplatesDir)
 
 	if err != nil {
 		return config, err
 	}
 
-	config.Template = tpl
+	config.Template = config.Templates[DefaultTemplateName].Template
+
+	if config.ListTemplatePath != "" {
+		config.ListTemplate, err = parseTemplateFile(config.ListTemplatePath)
+
+		if err != nil {
+			return config, err
+		}
+	}
+
+	if config.StatsTemplatePath != "" {
+		config.StatsTemplate, err = parseTemplateFile(config.StatsTemplatePath)
+
+		if err != nil {
+			return config, err
+		}
+	}
 
 	return config, err
 }
+
+func checkGrafanaAuth(config grafanaConfig) error {
+	methods := 0
+
+	for _, value := range []string{config.Token, config.TokenFile, config.BasicAuthUsername} {
+		if value != "" {
+			methods++
+		}
+	}
+
+	if methods > 1 {
+		return fmt.Errorf("only one of grafana token, token file and basic auth can be set")
+	}
+
+	if methods == 0 && len(config.Headers) == 0 {
+		return fmt.Errorf("one of grafana token, token file, basic auth or headers is required")
+	}
+
+	return nil
+}
+
+func weekdayNames() []string {
+	names := make([]string, 0, 7)
+
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		names = append(names, strings.ToLower(day.String()))
+	}
+
+	return names
+}
+
+func parseWeekday(name string) time.Weekday {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(day.String(), name) {
+			return day
+		}
+	}
+
+	return time.Monday
+}
+
+// parseTimeOfDay : parse HH:MM to duration since midnight
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
@@ -0,0 +1,139 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+)
+
+// DefaultGrafanaInstance : name of Grafana instance configured by command line flags
+const DefaultGrafanaInstance = "default"
+
+// grafanaInstanceFile : Grafana instance in the instances file
+type grafanaInstanceFile struct {
+	Name                  string            `json:"name"`
+	URL                   string            `json:"url"`
+	Token                 string            `json:"token"`
+	TokenFile             string            `json:"tokenFile"`
+	BasicAuthUsername     string            `json:"basicAuthUsername"`
+	BasicAuthPassword     string            `json:"basicAuthPassword"`
+	Headers               map[string]string `json:"headers"`
+	OrgIDs                []int64           `json:"orgIds"`
+	TLSInsecure           bool              `json:"tlsInsecure"`
+	TLSInsecureSkipVerify bool              `json:"tlsInsecureSkipVerify"`
+	TLSCert               string            `json:"tlsCert"`
+	TLSKey                string            `json:"tlsKey"`
+	TLSCA                 string            `json:"tlsCa"`
+	Timeout               string            `json:"timeout"`
+	ScrapeInterval        string            `json:"scrapeInterval"`
+}
+
+// loadGrafanaInstances : load Grafana instances list from JSON file, defaults are used for missing durations
+func loadGrafanaInstances(path string, defaults grafanaConfig) ([]grafanaConfig, error) {
+	content, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var instancesFile []grafanaInstanceFile
+
+	if err := json.Unmarshal(content, &instancesFile); err != nil {
+		return nil, fmt.Errorf("could not parse grafana instances file %s: %w", path, err)
+	}
+
+	if len(instancesFile) == 0 {
+		return nil, fmt.Errorf("no grafana instances in %s", path)
+	}
+
+	names := map[string]bool{}
+	instances := make([]grafanaConfig, 0, len(instancesFile))
+
+	for i, instanceFile := range instancesFile {
+		if instanceFile.Name == "" {
+			return nil, fmt.Errorf("grafana instance #%d: name is required", i+1)
+		}
+
+		if names[instanceFile.Name] {
+			return nil, fmt.Errorf("grafana instance %s: duplicate name", instanceFile.Name)
+		}
+
+		names[instanceFile.Name] = true
+
+		instance, err := instanceFile.toConfig(defaults)
+
+		if err != nil {
+			return nil, fmt.Errorf("grafana instance %s: %w", instanceFile.Name, err)
+		}
+
+		instances = append(instances, instance)
+	}
+
+	return instances, nil
+}
+
+func (f grafanaInstanceFile) toConfig(defaults grafanaConfig) (grafanaConfig, error) {
+	var err error
+
+	instance := grafanaConfig{
+		Name:                  f.Name,
+		Token:                 f.Token,
+		TokenFile:             f.TokenFile,
+		BasicAuthUsername:     f.BasicAuthUsername,
+		BasicAuthPassword:     f.BasicAuthPassword,
+		Headers:               f.Headers,
+		OrgIDs:                f.OrgIDs,
+		TLSInsecure:           f.TLSInsecure,
+		TLSInsecureSkipVerify: f.TLSInsecureSkipVerify,
+		TLSCert:               f.TLSCert,
+		TLSKey:                f.TLSKey,
+		TLSCA:                 f.TLSCA,
+		Timeout:               defaults.Timeout,
+		ScrapeInterval:        defaults.ScrapeInterval,
+	}
+
+	if f.URL == "" {
+		return instance, fmt.Errorf("url is required")
+	}
+
+	if instance.URL, err = url.Parse(f.URL); err != nil {
+		return instance, err
+	}
+
+	if f.Timeout != "" {
+		if instance.Timeout, err = time.ParseDuration(f.Timeout); err != nil {
+			return instance, fmt.Errorf("invalid timeout: %w", err)
+		}
+	}
+
+	if f.ScrapeInterval != "" {
+		if instance.ScrapeInterval, err = time.ParseDuration(f.ScrapeInterval); err != nil {
+			return instance, fmt.Errorf("invalid scrapeInterval: %w", err)
+		}
+	}
+
+	if err = checkGrafanaDurations(instance); err != nil {
+		return instance, err
+	}
+
+	if err = checkGrafanaAuth(instance); err != nil {
+		return instance, err
+	}
+
+	return instance, nil
+}
+
+// checkGrafanaDurations : durations must be positive, a zero scrape interval panics the poller ticker
+func checkGrafanaDurations(instance grafanaConfig) error {
+	if instance.ScrapeInterval <= 0 {
+		return fmt.Errorf("scrapeInterval must be greater than 0, got %s", instance.ScrapeInterval)
+	}
+
+	if instance.Timeout <= 0 {
+		return fmt.Errorf("timeout must be greater than 0, got %s", instance.Timeout)
+	}
+
+	return nil
+}
@@ -0,0 +1,239 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/templatefuncs"
+)
+
+const (
+	// DefaultTemplateName : name of the message template from --template.path
+	DefaultTemplateName = "default"
+
+	// ParseModeHTML : Telegram HTML, the template is escaped as HTML
+	ParseModeHTML = "HTML"
+	// ParseModeMarkdownV2 : Telegram MarkdownV2, the template is not escaped
+	ParseModeMarkdownV2 = "MarkdownV2"
+	// ParseModePlain : plain text without formatting, the template is not escaped
+	ParseModePlain = "plain"
+
+	templateExt          = ".tmpl"
+	optionsExt           = ".json"
+	frontMatterDelimiter = "---"
+)
+
+// TemplateExecutor : parsed template, html/template for HTML and text/template for other parse modes
+type TemplateExecutor interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// MessageTemplate : annotation message template
+type MessageTemplate struct {
+	Name     string
+	Path     string
+	Text     string
+	Template TemplateExecutor
+	Options  MessageOptions
+}
+
+// MessageOptions : message options of the template, from the template front matter or the sidecar JSON file
+type MessageOptions struct {
+	ParseMode             string            `json:"parseMode"`
+	DisableWebPagePreview bool              `json:"disableWebPagePreview"`
+	ProtectContent        bool              `json:"protectContent"`
+	Keyboard              [][]MessageButton `json:"keyboard"`
+}
+
+// MessageButton : URL button under the message, text and URL are templates
+type MessageButton struct {
+	Text string `json:"text"`
+	URL  string `json:"url"`
+
+	text *texttemplate.Template
+	url  *texttemplate.Template
+}
+
+// Render : text and URL of the button rendered with the message data
+func (b MessageButton) Render(data interface{}) (string, string, error) {
+	var text, url bytes.Buffer
+
+	if err := b.text.Execute(&text, data); err != nil {
+		return "", "", err
+	}
+
+	if err := b.url.Execute(&url, data); err != nil {
+		return "", "", err
+	}
+
+	return strings.TrimSpace(text.String()), strings.TrimSpace(url.String()), nil
+}
+
+// LoadMessageTemplates : load the default message template and named ones from *.tmpl files of the directory
+//
+// The template name is the file name without extension, the directory is optional.
+func LoadMessageTemplates(defaultPath string, dir string) (map[string]MessageTemplate, error) {
+	defaultTemplate, err := loadMessageTemplate(DefaultTemplateName, defaultPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	templates := map[string]MessageTemplate{DefaultTemplateName: defaultTemplate}
+	paths, err := MessageTemplateFiles(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), templateExt)
+
+		if name == DefaultTemplateName {
+			return nil, fmt.Errorf("template %s: name %q is reserved for --template.path", path, DefaultTemplateName)
+		}
+
+		if templates[name], err = loadMessageTemplate(name, path); err != nil {
+			return nil, err
+		}
+	}
+
+	return templates, nil
+}
+
+// MessageTemplateOptionsFile : sidecar JSON file with message options of the template file
+func MessageTemplateOptionsFile(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + optionsExt
+}
+
+// MessageTemplateFiles : named message template files of the directory, none for empty directory path
+func MessageTemplateFiles(dir string) ([]string, error) {
+	if dir == "" {
+		return nil, nil
+	}
+
+	return filepath.Glob(filepath.Join(dir, "*"+templateExt))
+}
+
+// parseTemplateFile : parse the template file with template functions
+func parseTemplateFile(path string) (*template.Template, error) {
+	return template.New(filepath.Base(path)).Funcs(templatefuncs.FuncMap()).ParseFiles(path)
+}
+
+func loadMessageTemplate(name string, path string) (MessageTemplate, error) {
+	text, err := os.ReadFile(path)
+
+	if err != nil {
+		return MessageTemplate{}, err
+	}
+
+	body, options, err := messageOptions(path, string(text))
+
+	if err != nil {
+		return MessageTemplate{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var tpl TemplateExecutor
+
+	if options.ParseMode == ParseModeHTML {
+		tpl, err = template.New(name).Funcs(templatefuncs.FuncMap()).Parse(body)
+	} else {
+		tpl, err = texttemplate.New(name).Funcs(texttemplate.FuncMap(templatefuncs.FuncMap())).Parse(body)
+	}
+
+	if err != nil {
+		return MessageTemplate{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return MessageTemplate{Name: name, Path: path, Text: string(text), Template: tpl, Options: options}, nil
+}
+
+// messageOptions : template body without the front matter and message options of the template
+//
+// Options are set by JSON front matter between "---" lines at the beginning of the template or by the sidecar
+// JSON file with the same name as the template, not both.
+func messageOptions(path string, text string) (string, MessageOptions, error) {
+	options := MessageOptions{}
+	body, frontMatter, hasFrontMatter := cutFrontMatter(text)
+
+	sidecar, err := os.ReadFile(MessageTemplateOptionsFile(path))
+
+	switch {
+	case err == nil && hasFrontMatter:
+		return "", options, fmt.Errorf("options are set both by the front matter and by %s", MessageTemplateOptionsFile(path))
+	case err == nil:
+		frontMatter = string(sidecar)
+	case !errors.Is(err, os.ErrNotExist):
+		return "", options, err
+	}
+
+	if strings.TrimSpace(frontMatter) != "" {
+		decoder := json.NewDecoder(strings.NewReader(frontMatter))
+		decoder.DisallowUnknownFields()
+
+		if err := decoder.Decode(&options); err != nil {
+			return "", options, fmt.Errorf("could not parse template options: %w", err)
+		}
+	}
+
+	if options.ParseMode == "" {
+		options.ParseMode = ParseModeHTML
+	}
+
+	if options.ParseMode != ParseModeHTML && options.ParseMode != ParseModeMarkdownV2 && options.ParseMode != ParseModePlain {
+		return "", options, fmt.Errorf("unknown parse mode %q, use %s, %s or %s", options.ParseMode, ParseModeHTML, ParseModeMarkdownV2, ParseModePlain)
+	}
+
+	for i, row := range options.Keyboard {
+		for j := range row {
+			if err := row[j].parse(); err != nil {
+				return "", options, fmt.Errorf("keyboard button %d of row %d: %w", j+1, i+1, err)
+			}
+		}
+	}
+
+	return body, options, nil
+}
+
+// cutFrontMatter : template body and the front matter, the front matter starts with "---" line and ends with
+// the next "---" line
+func cutFrontMatter(text string) (string, string, bool) {
+	normalized := strings.ReplaceAll(text, "\r\n", "\n")
+
+	if !strings.HasPrefix(normalized, frontMatterDelimiter+"\n") {
+		return text, "", false
+	}
+
+	rest := normalized[len(frontMatterDelimiter)+1:]
+	frontMatter, body, found := strings.Cut(rest, "\n"+frontMatterDelimiter+"\n")
+
+	if !found {
+		return text, "", false
+	}
+
+	return body, frontMatter, true
+}
+
+func (b *MessageButton) parse() error {
+	if b.Text == "" || b.URL == "" {
+		return errors.New("text and url are required")
+	}
+
+	var err error
+
+	if b.text, err = texttemplate.New("text").Funcs(texttemplate.FuncMap(templatefuncs.FuncMap())).Parse(b.Text); err != nil {
+		return err
+	}
+
+	b.url, err = texttemplate.New("url").Funcs(texttemplate.FuncMap(templatefuncs.FuncMap())).Parse(b.URL)
+
+	return err
+}
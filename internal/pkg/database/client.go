@@ -3,18 +3,17 @@ package database
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"github.com/kvtools/etcdv2"
 	"github.com/kvtools/etcdv3"
 	"github.com/kvtools/valkeyrie/store"
 	"gopkg.in/telebot.v3"
-	"io/ioutil"
 	"strings"
 	"time"
 
 	app "github.com/zt-sv/grafana-annotations-bot/internal/app/grafana-annotations-bot"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/tlsconfig"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -32,6 +31,7 @@ type DbClient struct {
 	logger         log.Logger
 	store          store.Store
 	storeKeyPrefix string
+	stateKeyPrefix string
 }
 
 // NewDB : Create new store client
@@ -59,6 +59,21 @@ func NewDB(config app.StorageConfig, logger log.Logger) (*DbClient, error) {
 		}
 
 		tlsConfig := &tls.Config{}
+
+		if !config.EtcdStoreConfig.TLSInsecure {
+			tlsConfig, err = tlsconfig.New(tlsconfig.Config{
+				CAFile:             config.EtcdStoreConfig.TLSCA,
+				CertFile:           config.EtcdStoreConfig.TLSCert,
+				KeyFile:            config.EtcdStoreConfig.TLSKey,
+				InsecureSkipVerify: config.EtcdStoreConfig.TLSInsecureSkipVerify,
+			}, logger)
+
+			if err != nil {
+				level.Error(logger).Log("msg", "failed to create store backend, could not create tls config", "err", err)
+				return nil, err
+			}
+		}
+
 		switch strings.ToLower(config.StoreType) {
 		case app.StoreTypeEtcdV2:
 			newStoreDriver = etcdv2.StoreName
@@ -68,31 +83,6 @@ func NewDB(config app.StorageConfig, logger log.Logger) (*DbClient, error) {
 			newStoreConfig = &etcdv3.Config{TLS: tlsConfig}
 		}
 
-		if !config.EtcdStoreConfig.TLSInsecure {
-			if config.EtcdStoreConfig.TLSCert != "" {
-				cert, err := tls.LoadX509KeyPair(config.EtcdStoreConfig.TLSCert, config.EtcdStoreConfig.TLSKey)
-				if err != nil {
-					level.Error(logger).Log("msg", "failed to create store backend, could not load certificates", "err", err)
-					return nil, err
-				}
-				tlsConfig.Certificates = []tls.Certificate{cert}
-			}
-
-			if config.EtcdStoreConfig.TLSCA != "" {
-				caCert, err := ioutil.ReadFile(config.EtcdStoreConfig.TLSCA)
-				if err != nil {
-					level.Error(logger).Log("msg", "failed to create store backend, could not load ca certificate", "err", err)
-					return nil, err
-				}
-
-				caCertPool := x509.NewCertPool()
-				caCertPool.AppendCertsFromPEM(caCert)
-				tlsConfig.RootCAs = caCertPool
-			}
-
-			tlsConfig.InsecureSkipVerify = config.EtcdStoreConfig.TLSInsecureSkipVerify
-		}
-
 	default:
 		level.Error(logger).Log("msg", fmt.Sprintf("Please provide one of the following supported store backends %s, %s. %s", app.StoreTypeBolt, app.StoreTypeEtcdV2, app.StoreTypeEtcdV3))
 		return nil, err
@@ -111,36 +101,56 @@ func NewDB(config app.StorageConfig, logger log.Logger) (*DbClient, error) {
 		logger:         logger,
 		store:          kvStore,
 		storeKeyPrefix: config.StoreKeyPrefix,
+		stateKeyPrefix: config.StateKeyPrefix,
 	}
 
 	return client, nil
 }
 
-// StoreValue : telebot chat and tags list
+// StoreValue : telebot chat and subscription filter
 type StoreValue struct {
 	Tags     []string
+	OrgID    int64
+	Source   string
 	ThreadID int
 	Chat     *telebot.Chat
+	// Created : subscription time, zero for subscriptions created by older versions
+	Created time.Time
+	// Template : name of the message template, the default one when empty
+	Template string
 }
 
-func (client *DbClient) createStoreKey(chat *telebot.Chat, thread int) string {
+// SubscriptionState : delivery state of the subscription, it is kept apart from the subscription
+// so the updates do not race with /start and /stop
+type SubscriptionState struct {
+	// LastMatched : time of the last annotation matched the subscription
+	LastMatched time.Time
+	// Notified : time of the last notification about the dead subscription
+	Notified time.Time
+}
+
+func chatKey(chat *telebot.Chat, thread int) string {
 	if thread != 0 {
-		return fmt.Sprintf("%s/%d-%d", client.storeKeyPrefix, chat.ID, thread)
+		return fmt.Sprintf("%d-%d", chat.ID, thread)
 	}
-	return fmt.Sprintf("%s/%d", client.storeKeyPrefix, chat.ID)
+	return fmt.Sprintf("%d", chat.ID)
 }
 
-// AddChatTags : Add telebot chat and subscribed tags to bolt store
-func (client *DbClient) AddChatTags(chat *telebot.Chat, thread int, tags []string) error {
+func (client *DbClient) createStoreKey(chat *telebot.Chat, thread int) string {
+	return fmt.Sprintf("%s/%s", client.storeKeyPrefix, chatKey(chat, thread))
+}
+
+func (client *DbClient) createStateKey(chat *telebot.Chat, thread int) string {
+	return fmt.Sprintf("%s/subscriptions/%s", client.stateKeyPrefix, chatKey(chat, thread))
+}
+
+// AddChat : Add telebot chat subscription to store
+func (client *DbClient) AddChat(value StoreValue) error {
 	ctx, cancel := context.WithTimeout(context.Background(), opsTimeout)
 
 	defer cancel()
 
-	storeValue, err := json.Marshal(&StoreValue{
-		Tags:     tags,
-		ThreadID: thread,
-		Chat:     chat,
-	})
+	storeValue, err := json.Marshal(&value)
 
 	if err != nil {
 		return err
@@ -148,7 +158,7 @@ func (client *DbClient) AddChatTags(chat *telebot.Chat, thread int, tags []strin
 
 	err = client.store.Put(
 		ctx,
-		client.createStoreKey(chat, thread),
+		client.createStoreKey(value.Chat, value.ThreadID),
 		storeValue,
 		nil,
 	)
@@ -156,24 +166,44 @@ func (client *DbClient) AddChatTags(chat *telebot.Chat, thread int, tags []strin
 	return err
 }
 
-// GetChatTags : Get subscribed tags for the chat from bolt store
-func (client *DbClient) GetChatTags(chat *telebot.Chat, thread int) ([]string, error) {
+// AddChatTags : Add telebot chat and subscribed tags to bolt store
+func (client *DbClient) AddChatTags(chat *telebot.Chat, thread int, tags []string) error {
+	return client.AddChat(StoreValue{
+		Tags:     tags,
+		ThreadID: thread,
+		Chat:     chat,
+	})
+}
+
+// GetChat : Get chat subscription from store
+func (client *DbClient) GetChat(chat *telebot.Chat, thread int) (StoreValue, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), opsTimeout)
 	pair, err := client.store.Get(ctx, client.createStoreKey(chat, thread), nil)
 
 	defer cancel()
 
+	value := StoreValue{ThreadID: 0}
+
 	if err != nil {
-		level.Error(client.logger).Log("msg", "failed to get chat tags", "err", err)
+		level.Error(client.logger).Log("msg", "failed to get chat", "err", err)
 
-		return nil, err
+		return value, err
 	}
 
-	value := StoreValue{ThreadID: 0}
+	err = json.Unmarshal(pair.Value, &value)
+
+	return value, err
+}
+
+// GetChatTags : Get subscribed tags for the chat from bolt store
+func (client *DbClient) GetChatTags(chat *telebot.Chat, thread int) ([]string, error) {
+	value, err := client.GetChat(chat, thread)
 
-	json.Unmarshal(pair.Value, &value)
+	if err != nil {
+		return nil, err
+	}
 
-	return value.Tags, err
+	return value.Tags, nil
 }
 
 // ExistChat : Check chat exist into bolt store
@@ -198,7 +228,20 @@ func (client *DbClient) ExistChat(chat *telebot.Chat, thread int) (bool, error)
 func (client *DbClient) Remove(chat *telebot.Chat, thread int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), opsTimeout)
 	defer cancel()
-	return client.store.Delete(ctx, client.createStoreKey(chat, thread))
+
+	err := client.store.Delete(ctx, client.createStoreKey(chat, thread))
+
+	if err != nil {
+		return err
+	}
+
+	err = client.store.Delete(ctx, client.createStateKey(chat, thread))
+
+	if err == store.ErrKeyNotFound {
+		return nil
+	}
+
+	return err
 }
 
 // List : Get chat and tags list from bolt store
@@ -208,6 +251,10 @@ func (client *DbClient) List() ([]StoreValue, error) {
 
 	defer cancel()
 
+	if err == store.ErrKeyNotFound {
+		return nil, nil
+	}
+
 	if err != nil {
 		level.Error(client.logger).Log("msg", fmt.Sprintf("Could not list %s keys", client.storeKeyPrefix), "err", err)
 		return nil, err
@@ -226,3 +273,80 @@ func (client *DbClient) List() ([]StoreValue, error) {
 
 	return values, nil
 }
+
+// Ping : Check store connectivity
+func (client *DbClient) Ping(ctx context.Context) error {
+	_, err := client.store.Exists(ctx, client.storeKeyPrefix, nil)
+
+	if err == store.ErrKeyNotFound {
+		return nil
+	}
+
+	return err
+}
+
+// Close : Close store connection
+func (client *DbClient) Close() error {
+	return client.store.Close()
+}
+
+// GetCursor : Get annotations source cursor, empty string when it is not saved yet
+func (client *DbClient) GetCursor(name string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), opsTimeout)
+	defer cancel()
+
+	pair, err := client.store.Get(ctx, fmt.Sprintf("%s/cursors/%s", client.stateKeyPrefix, name), nil)
+
+	if err == store.ErrKeyNotFound {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(pair.Value), nil
+}
+
+// SaveCursor : Save annotations source cursor
+func (client *DbClient) SaveCursor(name string, cursor string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), opsTimeout)
+	defer cancel()
+
+	return client.store.Put(ctx, fmt.Sprintf("%s/cursors/%s", client.stateKeyPrefix, name), []byte(cursor), nil)
+}
+
+// GetSubscriptionState : Get delivery state of the chat subscription, zero state when it is not saved yet
+func (client *DbClient) GetSubscriptionState(chat *telebot.Chat, thread int) (SubscriptionState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), opsTimeout)
+	defer cancel()
+
+	state := SubscriptionState{}
+	pair, err := client.store.Get(ctx, client.createStateKey(chat, thread), nil)
+
+	if err == store.ErrKeyNotFound {
+		return state, nil
+	}
+
+	if err != nil {
+		return state, err
+	}
+
+	err = json.Unmarshal(pair.Value, &state)
+
+	return state, err
+}
+
+// SaveSubscriptionState : Save delivery state of the chat subscription
+func (client *DbClient) SaveSubscriptionState(chat *telebot.Chat, thread int, state SubscriptionState) error {
+	ctx, cancel := context.WithTimeout(context.Background(), opsTimeout)
+	defer cancel()
+
+	value, err := json.Marshal(&state)
+
+	if err != nil {
+		return err
+	}
+
+	return client.store.Put(ctx, client.createStateKey(chat, thread), value, nil)
+}
@@ -0,0 +1,153 @@
+package grafana
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// Unknown rules reload the list, but not more often than that
+	alertRulesMinReload = time.Minute
+	// Data source of server side expressions
+	expressionDatasourceUID = "__expr__"
+)
+
+// AlertRuleDetails : alert rule with names of the queried data sources
+type AlertRuleDetails struct {
+	AlertRule
+	Datasources []string
+}
+
+// AlertRuleCache : alert rules of the organization, reloaded when stale
+type AlertRuleCache struct {
+	client      *Client
+	ttl         time.Duration
+	mu          sync.Mutex
+	loaded      time.Time
+	byID        map[int]AlertRule
+	byUID       map[string]AlertRule
+	legacy      map[int]AlertRule
+	datasources map[string]string
+}
+
+// NewAlertRuleCache : create alert rules cache, client selects the organization
+func NewAlertRuleCache(client *Client, ttl time.Duration) *AlertRuleCache {
+	return &AlertRuleCache{
+		client:      client,
+		ttl:         ttl,
+		byID:        map[int]AlertRule{},
+		byUID:       map[string]AlertRule{},
+		legacy:      map[int]AlertRule{},
+		datasources: map[string]string{},
+	}
+}
+
+// Get : get alert rule by unified alerting UID or by ID, legacy alerts are looked up by ID
+func (cache *AlertRuleCache) Get(ctx context.Context, id int, uid string) (AlertRuleDetails, bool, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	rule, ok := cache.lookup(id, uid)
+	age := time.Since(cache.loaded)
+
+	if (ok && age > cache.ttl) || (!ok && age > alertRulesMinReload) {
+		if err := cache.reload(ctx); err != nil {
+			if ok {
+				return cache.details(ctx, rule), true, nil
+			}
+
+			return AlertRuleDetails{}, false, err
+		}
+
+		rule, ok = cache.lookup(id, uid)
+	}
+
+	if !ok && uid == "" && id != 0 {
+		var err error
+
+		if rule, ok = cache.legacy[id]; !ok {
+			rule, err = cache.client.GetLegacyAlert(ctx, id)
+
+			if errors.Is(err, ErrNotFound) {
+				return AlertRuleDetails{}, false, nil
+			}
+
+			if err != nil {
+				return AlertRuleDetails{}, false, err
+			}
+
+			cache.legacy[id] = rule
+			ok = true
+		}
+	}
+
+	if !ok {
+		return AlertRuleDetails{}, false, nil
+	}
+
+	return cache.details(ctx, rule), true, nil
+}
+
+func (cache *AlertRuleCache) lookup(id int, uid string) (AlertRule, bool) {
+	if uid != "" {
+		rule, ok := cache.byUID[uid]
+		return rule, ok
+	}
+
+	rule, ok := cache.byID[id]
+
+	return rule, ok
+}
+
+func (cache *AlertRuleCache) reload(ctx context.Context) error {
+	// Failed reloads are rate limited too
+	cache.loaded = time.Now()
+
+	rules, err := cache.client.GetAlertRules(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	cache.byID = make(map[int]AlertRule, len(rules))
+	cache.byUID = make(map[string]AlertRule, len(rules))
+
+	for _, rule := range rules {
+		cache.byID[rule.ID] = rule
+		cache.byUID[rule.UID] = rule
+	}
+
+	return nil
+}
+
+// details : resolve data source names, unknown data sources are shown by UID
+func (cache *AlertRuleCache) details(ctx context.Context, rule AlertRule) AlertRuleDetails {
+	details := AlertRuleDetails{AlertRule: rule}
+	seen := map[string]bool{}
+
+	for _, query := range rule.Data {
+		uid := query.DatasourceUID
+
+		if uid == "" || uid == expressionDatasourceUID || seen[uid] {
+			continue
+		}
+
+		seen[uid] = true
+		name, ok := cache.datasources[uid]
+
+		if !ok {
+			name = uid
+
+			if datasource, err := cache.client.GetDatasource(ctx, uid); err == nil {
+				name = datasource.Name
+				cache.datasources[uid] = name
+			}
+		}
+
+		details.Datasources = append(details.Datasources, name)
+	}
+
+	return details
+}
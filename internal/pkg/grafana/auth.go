@@ -0,0 +1,144 @@
+package grafana
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// Authenticator : sets credentials on Grafana API requests
+type Authenticator interface {
+	// Authenticate : set credentials on the request
+	Authenticate(req *http.Request) error
+	// Refresh : re-read credentials after they were rejected, returns true when they changed
+	Refresh() bool
+}
+
+type bearerAuth struct {
+	token string
+}
+
+func (a *bearerAuth) Authenticate(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	return nil
+}
+
+func (a *bearerAuth) Refresh() bool {
+	return false
+}
+
+type basicAuth struct {
+	username string
+	password string
+}
+
+func (a *basicAuth) Authenticate(req *http.Request) error {
+	req.SetBasicAuth(a.username, a.password)
+	return nil
+}
+
+func (a *basicAuth) Refresh() bool {
+	return false
+}
+
+// tokenFileAuth : bearer token read from file, e.g. mounted Kubernetes secret
+type tokenFileAuth struct {
+	mu      sync.Mutex
+	path    string
+	token   string
+	modTime time.Time
+	logger  log.Logger
+}
+
+func newTokenFileAuth(path string, logger log.Logger) (*tokenFileAuth, error) {
+	auth := &tokenFileAuth{path: path, logger: logger}
+
+	if _, err := auth.load(false); err != nil {
+		return nil, err
+	}
+
+	return auth, nil
+}
+
+// load : read token when the file is changed or force is set, returns true when the token changed
+func (a *tokenFileAuth) load(force bool) (bool, error) {
+	info, err := os.Stat(a.path)
+
+	if err != nil {
+		return false, err
+	}
+
+	if !force && a.token != "" && info.ModTime().Equal(a.modTime) {
+		return false, nil
+	}
+
+	content, err := os.ReadFile(a.path)
+
+	if err != nil {
+		return false, err
+	}
+
+	token := strings.TrimSpace(string(content))
+
+	if token == "" {
+		return false, fmt.Errorf("token file %s is empty", a.path)
+	}
+
+	changed := token != a.token
+
+	if changed && a.token != "" {
+		level.Info(a.logger).Log("msg", "grafana token reloaded", "file", a.path)
+	}
+
+	a.token = token
+	a.modTime = info.ModTime()
+
+	return changed, nil
+}
+
+func (a *tokenFileAuth) Authenticate(req *http.Request) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Keep the previous token while the new one is being written
+	if _, err := a.load(false); err != nil {
+		level.Error(a.logger).Log("msg", "failed to reload grafana token, use the previous one", "err", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+a.token)
+
+	return nil
+}
+
+func (a *tokenFileAuth) Refresh() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	changed, err := a.load(true)
+
+	if err != nil {
+		level.Error(a.logger).Log("msg", "failed to reload grafana token", "err", err)
+	}
+
+	return changed
+}
+
+func newAuthenticator(config ClientConfig) (Authenticator, error) {
+	switch {
+	case config.TokenFile != "":
+		return newTokenFileAuth(config.TokenFile, config.Logger)
+	case config.Token != "":
+		return &bearerAuth{token: config.Token}, nil
+	case config.BasicAuthUsername != "":
+		return &basicAuth{username: config.BasicAuthUsername, password: config.BasicAuthPassword}, nil
+	}
+
+	// Auth proxy headers only
+	return nil, nil
+}
@@ -1,83 +1,136 @@
 package grafana
 
 import (
-	"crypto/tls"
-	"io/ioutil"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"path"
+	"strconv"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/tlsconfig"
+)
+
+const (
+	dialTimeout         = 10 * time.Second
+	keepAlive           = 30 * time.Second
+	maxIdleConns        = 10
+	idleConnTimeout     = 90 * time.Second
+	tlsHandshakeTimeout = 10 * time.Second
 )
 
 // Client : Grafana client
 type Client struct {
-	grafanaURL  *url.URL
-	token       string
-	TLSInsecure bool
-	skipVerify  bool
-	caFile      string
-	certFile    string
-	keyFile     string
-	logger      log.Logger
+	name       string
+	grafanaURL *url.URL
+	auth       Authenticator
+	headers    map[string]string
+	orgID      int64
+	httpClient *http.Client
+	logger     log.Logger
 }
 
 // ClientConfig : Grafana client config
 type ClientConfig struct {
-	URL         *url.URL
-	Token       string
-	TLSInsecure bool
-	SkipVerify  bool
-	CertFile    string
-	KeyFile     string
-	Logger      log.Logger
+	Name              string
+	URL               *url.URL
+	Token             string
+	TokenFile         string
+	BasicAuthUsername string
+	BasicAuthPassword string
+	Headers           map[string]string
+	TLSInsecure       bool
+	SkipVerify        bool
+	CAFile            string
+	CertFile          string
+	KeyFile           string
+	Timeout           time.Duration
+	Logger            log.Logger
 }
 
 // NewClient : create new Grafana client
 func NewClient(config ClientConfig) (*Client, error) {
-	client := &Client{
-		grafanaURL:  config.URL,
-		token:       config.Token,
-		TLSInsecure: config.TLSInsecure,
-		skipVerify:  config.SkipVerify,
-		certFile:    config.CertFile,
-		keyFile:     config.KeyFile,
-		logger:      config.Logger,
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   dialTimeout,
+			KeepAlive: keepAlive,
+		}).DialContext,
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   maxIdleConns,
+		IdleConnTimeout:       idleConnTimeout,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ExpectContinueTimeout: time.Second,
 	}
 
-	return client, nil
-}
-
-func (client *Client) getHTTPClient() *http.Client {
-	if !client.TLSInsecure {
-		cert, err := tls.LoadX509KeyPair(client.certFile, client.keyFile)
+	if !config.TLSInsecure {
+		tlsConfig, err := tlsconfig.New(tlsconfig.Config{
+			CAFile:             config.CAFile,
+			CertFile:           config.CertFile,
+			KeyFile:            config.KeyFile,
+			InsecureSkipVerify: config.SkipVerify,
+		}, config.Logger)
 
 		if err != nil {
-			level.Error(client.logger).Log("msg", "failed to load keys", "err", err)
+			level.Error(config.Logger).Log("msg", "failed to create tls config", "err", err)
+			return nil, err
 		}
 
-		tr := &http.Transport{
-			TLSClientConfig: &tls.Config{
-				Certificates:       []tls.Certificate{cert},
-				InsecureSkipVerify: client.skipVerify,
-			},
-		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	auth, err := newAuthenticator(config)
 
-		return &http.Client{Transport: tr}
+	if err != nil {
+		level.Error(config.Logger).Log("msg", "failed to create grafana authenticator", "err", err)
+		return nil, err
 	}
 
-	return &http.Client{}
+	client := &Client{
+		name:       config.Name,
+		grafanaURL: config.URL,
+		auth:       auth,
+		headers:    config.Headers,
+		httpClient: &http.Client{Transport: transport, Timeout: config.Timeout},
+		logger:     config.Logger,
+	}
+
+	return client, nil
 }
 
-func (client *Client) getEndpointURL(endpoint string, query map[string]string) string {
+// WithOrg : get client for the Grafana organization, 0 means the default organization of the user
+func (client *Client) WithOrg(orgID int64) *Client {
+	orgClient := *client
+	orgClient.orgID = orgID
+	orgClient.logger = log.With(client.logger, "org_id", orgID)
+
+	return &orgClient
+}
+
+// Name : get Grafana instance name
+func (client *Client) Name() string {
+	return client.name
+}
+
+// OrgID : get client organization ID
+func (client *Client) OrgID() int64 {
+	return client.orgID
+}
+
+func (client *Client) getEndpointURL(endpoint string, query url.Values) string {
 	uri, _ := url.Parse(client.grafanaURL.String())
 	uri.Path = path.Join(uri.Path, endpoint)
 
 	q := uri.Query()
 
 	for k := range query {
-		q.Set(k, query[k])
+		q[k] = query[k]
 	}
 
 	uri.RawQuery = q.Encode()
@@ -85,34 +138,123 @@ func (client *Client) getEndpointURL(endpoint string, query map[string]string) s
 	return uri.String()
 }
 
-func (client *Client) apiGetRequest(apiPath string, query map[string]string) (string, error) {
+func (client *Client) apiRequest(ctx context.Context, method string, apiPath string, query url.Values, body []byte) ([]byte, error) {
 	var (
 		endpoint = client.getEndpointURL(apiPath, query)
 	)
 
-	httpClient := client.getHTTPClient()
-	req, _ := http.NewRequest("GET", endpoint, nil)
-	req.Header.Set("Authorization", "Bearer "+client.token)
-	resp, err := httpClient.Do(req)
+	resp, err := client.doRequest(ctx, method, endpoint, body)
+
+	if err == nil && resp.StatusCode == http.StatusUnauthorized && client.auth != nil && client.auth.Refresh() {
+		level.Info(client.logger).Log("msg", "retry request with refreshed credentials", "endpoint", endpoint)
+		resp.Body.Close()
+		resp, err = client.doRequest(ctx, method, endpoint, body)
+	}
 
 	if err != nil {
-		level.Error(client.logger).Log("msg", "could not get request to "+endpoint, "err", err)
+		level.Error(client.logger).Log("msg", "could not "+method+" request to "+endpoint, "err", err)
 
-		return string(""), err
+		return nil, err
 	}
 
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusOK {
-		bodyBytes, err := ioutil.ReadAll(resp.Body)
+	bodyBytes, err := io.ReadAll(resp.Body)
 
-		if err != nil {
-			level.Error(client.logger).Log("msg", "error parsing response body", "err", err)
+	if err != nil {
+		level.Error(client.logger).Log("msg", "error reading response body", "err", err)
+
+		return nil, err
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		apiErr := newAPIError(endpoint, resp, bodyBytes)
+		level.Error(client.logger).Log("msg", method+" request to "+endpoint+" finished with error", "err", apiErr, "status code", resp.StatusCode)
+
+		return nil, apiErr
+	}
+
+	return bodyBytes, nil
+}
+
+func (client *Client) doRequest(ctx context.Context, method string, endpoint string, body []byte) (*http.Response, error) {
+	var reqBody io.Reader
+
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reqBody)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range client.headers {
+		req.Header.Set(k, v)
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if client.orgID != 0 {
+		req.Header.Set("X-Grafana-Org-Id", strconv.FormatInt(client.orgID, 10))
+	}
+
+	if client.auth != nil {
+		if err := client.auth.Authenticate(req); err != nil {
+			return nil, err
 		}
+	}
+
+	return client.httpClient.Do(req)
+}
+
+func (client *Client) apiGet(ctx context.Context, apiPath string, query url.Values, v interface{}) error {
+	body, err := client.apiRequest(ctx, http.MethodGet, apiPath, query, nil)
+
+	if err != nil {
+		return err
+	}
 
-		return string(bodyBytes), nil
+	return client.decode(apiPath, query, body, v)
+}
+
+// apiPost : post v as JSON and decode JSON response to result, nil result ignores response body
+func (client *Client) apiPost(ctx context.Context, apiPath string, v interface{}, result interface{}) error {
+	reqBody, err := json.Marshal(v)
+
+	if err != nil {
+		return err
+	}
+
+	body, err := client.apiRequest(ctx, http.MethodPost, apiPath, nil, reqBody)
+
+	if err != nil {
+		return err
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return client.decode(apiPath, nil, body, result)
+}
+
+func (client *Client) apiDelete(ctx context.Context, apiPath string) error {
+	_, err := client.apiRequest(ctx, http.MethodDelete, apiPath, nil, nil)
+	return err
+}
+
+func (client *Client) decode(apiPath string, query url.Values, body []byte, v interface{}) error {
+	if err := json.Unmarshal(body, v); err != nil {
+		return &DecodeError{
+			Endpoint: client.getEndpointURL(apiPath, query),
+			Body:     truncateBody(body),
+			Err:      err,
+		}
 	}
 
-	level.Error(client.logger).Log("msg", "request to "+endpoint+" finished with error ", "err", err, "status code", resp.StatusCode)
-	return string(""), err
+	return nil
 }
@@ -0,0 +1,118 @@
+package grafana
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+const silencesPath = "/api/alertmanager/grafana/api/v2/silences"
+
+// matcherRegexp : label matcher, e.g. alertname="HighCPU" or severity=~"critical|warning"
+var matcherRegexp = regexp.MustCompile(`^\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*(=~|!~|!=|=)\s*(.*?)\s*$`)
+
+// Matcher : Alertmanager label matcher
+type Matcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+	IsEqual bool   `json:"isEqual"`
+}
+
+// String : format matcher in Alertmanager syntax
+func (m Matcher) String() string {
+	operator := "="
+
+	switch {
+	case m.IsRegex && m.IsEqual:
+		operator = "=~"
+	case m.IsRegex:
+		operator = "!~"
+	case !m.IsEqual:
+		operator = "!="
+	}
+
+	return fmt.Sprintf("%s%s%q", m.Name, operator, m.Value)
+}
+
+// ParseMatcher : parse matcher in Alertmanager syntax, the value may be quoted
+func ParseMatcher(s string) (Matcher, error) {
+	match := matcherRegexp.FindStringSubmatch(s)
+
+	if match == nil {
+		return Matcher{}, fmt.Errorf("bad matcher %q", s)
+	}
+
+	value := match[3]
+
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+		value = value[1 : len(value)-1]
+	}
+
+	matcher := Matcher{
+		Name:    match[1],
+		Value:   value,
+		IsRegex: match[2] == "=~" || match[2] == "!~",
+		IsEqual: match[2] == "=" || match[2] == "=~",
+	}
+
+	if matcher.IsRegex {
+		if _, err := regexp.Compile(matcher.Value); err != nil {
+			return Matcher{}, fmt.Errorf("bad matcher %q: %w", s, err)
+		}
+	}
+
+	return matcher, nil
+}
+
+// MatchersFromLabels : equality matchers for alert labels, Grafana internal labels are skipped
+func MatchersFromLabels(labels map[string]string) []Matcher {
+	matchers := make([]Matcher, 0, len(labels))
+
+	for name, value := range labels {
+		if strings.HasPrefix(name, "__") {
+			continue
+		}
+
+		matchers = append(matchers, Matcher{Name: name, Value: value, IsEqual: true})
+	}
+
+	sort.Slice(matchers, func(i, j int) bool {
+		return matchers[i].Name < matchers[j].Name
+	})
+
+	return matchers
+}
+
+// Silence : Alertmanager silence
+type Silence struct {
+	ID        string    `json:"id,omitempty"`
+	Matchers  []Matcher `json:"matchers"`
+	StartsAt  time.Time `json:"startsAt"`
+	EndsAt    time.Time `json:"endsAt"`
+	CreatedBy string    `json:"createdBy"`
+	Comment   string    `json:"comment"`
+}
+
+type createSilenceResp struct {
+	SilenceID string `json:"silenceID"`
+}
+
+// CreateSilence : create silence in Grafana Alertmanager, returns silence ID
+func (client *Client) CreateSilence(ctx context.Context, silence Silence) (string, error) {
+	respJSON := createSilenceResp{}
+	err := client.apiPost(ctx, silencesPath, silence, &respJSON)
+
+	if err != nil {
+		level.Error(client.logger).Log("msg", "could not create silence", "err", err)
+
+		return "", err
+	}
+
+	return respJSON.SilenceID, nil
+}
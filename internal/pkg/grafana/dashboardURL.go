@@ -0,0 +1,48 @@
+package grafana
+
+import (
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+)
+
+// Time range around the annotation shown by dashboard links
+const dashboardURLTimeMargin = time.Hour
+
+// DashboardURL : link to the annotation dashboard around the annotation time, empty for organization annotations
+func (client *Client) DashboardURL(annotation Annotation) string {
+	if annotation.DashboardUID == "" {
+		return ""
+	}
+
+	at := time.UnixMilli(annotation.Time)
+
+	return client.dashboardURL(annotation.DashboardUID, annotation.OrgID, at.Add(-dashboardURLTimeMargin), at.Add(dashboardURLTimeMargin), annotation.PanelID)
+}
+
+// DashboardRangeURL : link to the dashboard for the time range
+func (client *Client) DashboardRangeURL(uid string, orgID int64, from time.Time, to time.Time) string {
+	return client.dashboardURL(uid, orgID, from, to, 0)
+}
+
+func (client *Client) dashboardURL(uid string, orgID int64, from time.Time, to time.Time, panelID int) string {
+	uri := *client.grafanaURL
+	uri.Path = path.Join(uri.Path, "/d/", url.PathEscape(uid))
+
+	query := url.Values{}
+	query.Set("from", strconv.FormatInt(from.UnixMilli(), 10))
+	query.Set("to", strconv.FormatInt(to.UnixMilli(), 10))
+
+	if orgID != 0 {
+		query.Set("orgId", strconv.FormatInt(orgID, 10))
+	}
+
+	if panelID != 0 {
+		query.Set("viewPanel", strconv.Itoa(panelID))
+	}
+
+	uri.RawQuery = query.Encode()
+
+	return uri.String()
+}
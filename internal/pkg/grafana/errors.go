@@ -0,0 +1,159 @@
+package grafana
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Grafana API error kinds, use errors.Is to check them
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrServerError  = errors.New("server error")
+	ErrBadResponse  = errors.New("unexpected response")
+)
+
+// Response bodies longer than this are truncated in errors
+const maxErrorBodyLength = 1024
+
+// APIError : Grafana API responded with non 200 status code
+type APIError struct {
+	Endpoint   string
+	StatusCode int
+	Body       string
+	// RetryAfter : delay requested by Grafana for rate limited or unavailable requests, 0 when not set
+	RetryAfter time.Duration
+	kind       error
+}
+
+func newAPIError(endpoint string, resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{
+		Endpoint:   endpoint,
+		StatusCode: resp.StatusCode,
+		Body:       truncateBody(body),
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		apiErr.kind = ErrUnauthorized
+	case resp.StatusCode == http.StatusForbidden:
+		apiErr.kind = ErrForbidden
+	case resp.StatusCode == http.StatusNotFound:
+		apiErr.kind = ErrNotFound
+	case resp.StatusCode == http.StatusTooManyRequests:
+		apiErr.kind = ErrRateLimited
+		apiErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	case resp.StatusCode >= http.StatusInternalServerError:
+		apiErr.kind = ErrServerError
+
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			apiErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+		}
+	default:
+		apiErr.kind = ErrBadResponse
+	}
+
+	return apiErr
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("request to %s failed: %v (status code %d): %s", e.Endpoint, e.kind, e.StatusCode, e.Body)
+}
+
+// Unwrap : get error kind
+func (e *APIError) Unwrap() error {
+	return e.kind
+}
+
+// RetryDelay : minimum delay before retrying the request, implements retry.Delayer
+func (e *APIError) RetryDelay() time.Duration {
+	return e.RetryAfter
+}
+
+// parseRetryAfter : parse Retry-After header in seconds or HTTP date, 0 when not set or invalid
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil && time.Until(date) > 0 {
+		return time.Until(date)
+	}
+
+	return 0
+}
+
+// DecodeError : Grafana API response could not be decoded
+type DecodeError struct {
+	Endpoint string
+	Body     string
+	Err      error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("could not decode response from %s: %v: %s", e.Endpoint, e.Err, e.Body)
+}
+
+// Unwrap : get decoding error
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// IsRetryable : whether the failed request may succeed when retried
+func IsRetryable(err error) bool {
+	var decodeErr *DecodeError
+
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, ErrRateLimited), errors.Is(err, ErrServerError):
+		return true
+	case errors.As(err, &decodeErr):
+		return false
+	case errors.Is(err, ErrUnauthorized),
+		errors.Is(err, ErrForbidden),
+		errors.Is(err, ErrNotFound),
+		errors.Is(err, ErrBadResponse):
+		return false
+	}
+
+	// Network errors
+	return true
+}
+
+// Describe : get human readable explanation of the error
+func Describe(err error) string {
+	var decodeErr *DecodeError
+
+	switch {
+	case errors.Is(err, ErrUnauthorized):
+		return "Grafana rejected the credentials, the token may be expired"
+	case errors.Is(err, ErrForbidden):
+		return "Grafana credentials have no access to the API"
+	case errors.Is(err, ErrNotFound):
+		return "Grafana API endpoint is not found, check the URL"
+	case errors.Is(err, ErrRateLimited):
+		return "Grafana rate limits the requests"
+	case errors.Is(err, ErrServerError):
+		return "Grafana server error"
+	case errors.As(err, &decodeErr):
+		return "Grafana response is malformed, check the URL"
+	case errors.Is(err, ErrBadResponse):
+		return "Grafana unexpected response"
+	}
+
+	return "Grafana is unreachable"
+}
+
+func truncateBody(body []byte) string {
+	if len(body) > maxErrorBodyLength {
+		return string(body[:maxErrorBodyLength]) + "..."
+	}
+
+	return string(body)
+}
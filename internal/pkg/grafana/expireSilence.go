@@ -0,0 +1,21 @@
+package grafana
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+const silencePath = "/api/alertmanager/grafana/api/v2/silence/"
+
+// ExpireSilence : expire silence in Grafana Alertmanager
+func (client *Client) ExpireSilence(ctx context.Context, silenceID string) error {
+	err := client.apiDelete(ctx, silencePath+url.PathEscape(silenceID))
+
+	if err != nil {
+		level.Error(client.logger).Log("msg", "could not expire silence", "silence", silenceID, "err", err)
+	}
+
+	return err
+}
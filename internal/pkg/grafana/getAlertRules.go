@@ -0,0 +1,97 @@
+package grafana
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// AlertQuery : alert rule query
+type AlertQuery struct {
+	RefID         string `json:"refId"`
+	DatasourceUID string `json:"datasourceUid"`
+}
+
+// AlertRule : Grafana alert rule
+type AlertRule struct {
+	ID          int               `json:"id"`
+	UID         string            `json:"uid"`
+	Title       string            `json:"title"`
+	FolderUID   string            `json:"folderUID"`
+	RuleGroup   string            `json:"ruleGroup"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	Data        []AlertQuery      `json:"data"`
+}
+
+// legacyAlert : alert of the legacy dashboard alerting
+type legacyAlert struct {
+	ID          int    `json:"Id"`
+	DashboardID int    `json:"DashboardId"`
+	PanelID     int    `json:"PanelId"`
+	Name        string `json:"Name"`
+	Message     string `json:"Message"`
+}
+
+// Datasource : Grafana data source
+type Datasource struct {
+	UID  string `json:"uid"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// GetAlertRules : get unified alerting rules of the organization
+func (client *Client) GetAlertRules(ctx context.Context) ([]AlertRule, error) {
+	respJSON := []AlertRule{}
+	err := client.apiGet(ctx, "/api/v1/provisioning/alert-rules", nil, &respJSON)
+
+	if err != nil {
+		level.Error(client.logger).Log("msg", "could not get alert rules", "err", err)
+
+		return nil, err
+	}
+
+	return respJSON, nil
+}
+
+// GetLegacyAlert : get legacy dashboard alert as alert rule, returns ErrNotFound when Grafana has no legacy alerting
+func (client *Client) GetLegacyAlert(ctx context.Context, alertID int) (AlertRule, error) {
+	respJSON := legacyAlert{}
+	err := client.apiGet(ctx, "/api/alerts/"+strconv.Itoa(alertID), nil, &respJSON)
+
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			level.Error(client.logger).Log("msg", "could not get legacy alert", "alert", alertID, "err", err)
+		}
+
+		return AlertRule{}, err
+	}
+
+	rule := AlertRule{
+		ID:    respJSON.ID,
+		Title: respJSON.Name,
+	}
+
+	if respJSON.Message != "" {
+		rule.Annotations = map[string]string{"message": respJSON.Message}
+	}
+
+	return rule, nil
+}
+
+// GetDatasource : get data source by UID
+func (client *Client) GetDatasource(ctx context.Context, uid string) (Datasource, error) {
+	respJSON := Datasource{}
+	err := client.apiGet(ctx, "/api/datasources/uid/"+url.PathEscape(uid), nil, &respJSON)
+
+	if err != nil {
+		level.Error(client.logger).Log("msg", "could not get datasource", "datasource", uid, "err", err)
+
+		return respJSON, err
+	}
+
+	return respJSON, nil
+}
@@ -0,0 +1,46 @@
+package grafana
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// TagCount : annotation tag with number of annotations
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+type annotationTagsResp struct {
+	Result struct {
+		Tags []TagCount `json:"tags"`
+	} `json:"result"`
+}
+
+// GetAnnotationTags : get annotation tags containing the search string, the most used first
+func (client *Client) GetAnnotationTags(ctx context.Context, search string, limit int) ([]TagCount, error) {
+	respJSON := annotationTagsResp{}
+
+	query := url.Values{}
+
+	if search != "" {
+		query.Set("tag", search)
+	}
+
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	err := client.apiGet(ctx, "/api/annotations/tags", query, &respJSON)
+
+	if err != nil {
+		level.Error(client.logger).Log("msg", "could not get annotation tags", "err", err)
+
+		return nil, err
+	}
+
+	return respJSON.Result.Tags, nil
+}
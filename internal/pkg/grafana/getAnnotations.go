@@ -1,7 +1,8 @@
 package grafana
 
 import (
-	"encoding/json"
+	"context"
+	"net/url"
 	"strconv"
 	"time"
 
@@ -10,40 +11,78 @@ import (
 
 // Annotation : grafana annotation
 type Annotation struct {
-	ID          int
-	AlertID     int
-	DashboardID int
-	PanelID     int
-	UserID      int
-	UserName    string
-	NewState    string
-	PrevState   string
-	Time        int64
-	Text        string
-	Metric      string
-	RegionID    int
-	Tags        []string
+	ID           int
+	Source       string
+	OrgID        int64
+	AlertID      int
+	AlertUID     string
+	AlertName    string
+	DashboardID  int
+	DashboardUID string
+	PanelID      int
+	UserID       int
+	UserName     string
+	Login        string
+	NewState     string
+	PrevState    string
+	Time         int64
+	Text         string
+	Metric       string
+	RegionID     int
+	Tags         []string
+	Labels       map[string]string
 }
 
 // AnnotationsResp : Grafana annotations list
 type AnnotationsResp []Annotation
 
+// AnnotationsQuery : annotations search parameters, zero values are not sent
+type AnnotationsQuery struct {
+	From  time.Time
+	To    time.Time
+	Limit int
+	Tags  []string
+}
+
 // GetAnnotations : get annotations list from Grafana
-func (client *Client) GetAnnotations(fromTime time.Time, toTime time.Time) (AnnotationsResp, error) {
+func (client *Client) GetAnnotations(ctx context.Context, fromTime time.Time, toTime time.Time) (AnnotationsResp, error) {
+	return client.FindAnnotations(ctx, AnnotationsQuery{From: fromTime, To: toTime})
+}
+
+// FindAnnotations : search annotations, Grafana returns the newest annotations first
+func (client *Client) FindAnnotations(ctx context.Context, annotationsQuery AnnotationsQuery) (AnnotationsResp, error) {
 	respJSON := AnnotationsResp{}
 
-	query := map[string]string{}
-	query["from"] = strconv.FormatInt(fromTime.UnixNano()/int64(time.Millisecond), 10)
-	query["to"] = strconv.FormatInt(toTime.UnixNano()/int64(time.Millisecond), 10)
+	query := url.Values{}
 
-	respText, err := client.apiGetRequest("/api/annotations", query)
+	if !annotationsQuery.From.IsZero() {
+		query.Set("from", strconv.FormatInt(annotationsQuery.From.UnixNano()/int64(time.Millisecond), 10))
+	}
+
+	if !annotationsQuery.To.IsZero() {
+		query.Set("to", strconv.FormatInt(annotationsQuery.To.UnixNano()/int64(time.Millisecond), 10))
+	}
+
+	if annotationsQuery.Limit > 0 {
+		query.Set("limit", strconv.Itoa(annotationsQuery.Limit))
+	}
+
+	for _, tag := range annotationsQuery.Tags {
+		query.Add("tags", tag)
+	}
+
+	err := client.apiGet(ctx, "/api/annotations", query, &respJSON)
 
 	if err != nil {
 		level.Error(client.logger).Log("msg", "could not get grafana annotations", "err", err)
 		return respJSON, err
 	}
 
-	json.Unmarshal([]byte(respText), &respJSON)
+	// Grafana does not return organization of annotations
+	for i := range respJSON {
+		respJSON[i].Source = client.name
+		respJSON[i].OrgID = client.orgID
+	}
 
 	return respJSON, nil
 }
@@ -0,0 +1,29 @@
+package grafana
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+type dashboardResp struct {
+	Dashboard struct {
+		UID   string `json:"uid"`
+		Title string `json:"title"`
+	} `json:"dashboard"`
+}
+
+// GetDashboardTitle : get dashboard title by UID
+func (client *Client) GetDashboardTitle(ctx context.Context, uid string) (string, error) {
+	respJSON := dashboardResp{}
+	err := client.apiGet(ctx, "/api/dashboards/uid/"+url.PathEscape(uid), nil, &respJSON)
+
+	if err != nil {
+		level.Error(client.logger).Log("msg", "could not get dashboard", "dashboard", uid, "err", err)
+
+		return "", err
+	}
+
+	return respJSON.Dashboard.Title, nil
+}
@@ -1,7 +1,7 @@
 package grafana
 
 import (
-	"encoding/json"
+	"context"
 
 	"github.com/go-kit/kit/log/level"
 )
@@ -14,9 +14,9 @@ type HealthResp struct {
 }
 
 // GetStatus : Get Grafana status
-func (client *Client) GetStatus() (HealthResp, error) {
+func (client *Client) GetStatus(ctx context.Context) (HealthResp, error) {
 	respJSON := HealthResp{}
-	respText, err := client.apiGetRequest("/api/health", nil)
+	err := client.apiGet(ctx, "/api/health", nil, &respJSON)
 
 	if err != nil {
 		level.Error(client.logger).Log("msg", "could not get grafana health status", "err", err)
@@ -24,7 +24,5 @@ func (client *Client) GetStatus() (HealthResp, error) {
 		return respJSON, err
 	}
 
-	json.Unmarshal([]byte(respText), &respJSON)
-
 	return respJSON, nil
 }
@@ -0,0 +1,72 @@
+package health
+
+import (
+	"sync"
+	"time"
+)
+
+// Dependency : availability of an external dependency, updated by its users
+type Dependency struct {
+	mu        sync.RWMutex
+	downSince time.Time
+	lastErr   error
+	failures  int
+}
+
+// NewDependency : create dependency in available state
+func NewDependency() *Dependency {
+	return &Dependency{}
+}
+
+// Fail : record failed call, returns true when the dependency was available before
+func (d *Dependency) Fail(err error) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.lastErr = err
+	d.failures++
+
+	if d.downSince.IsZero() {
+		d.downSince = time.Now()
+		return true
+	}
+
+	return false
+}
+
+// Recover : record successful call, returns true when the dependency was unavailable before
+func (d *Dependency) Recover() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	wasDown := !d.downSince.IsZero()
+	d.downSince = time.Time{}
+	d.lastErr = nil
+	d.failures = 0
+
+	return wasDown
+}
+
+// DownSince : get time since the dependency is unavailable
+func (d *Dependency) DownSince() (time.Time, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.downSince, !d.downSince.IsZero()
+}
+
+// LastError : get the last error, nil when the dependency is available
+func (d *Dependency) LastError() error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.lastErr
+}
+
+// Failures : get number of consecutive failed calls
+func (d *Dependency) Failures() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.failures
+}
@@ -0,0 +1,166 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+const (
+	// LivenessPath : liveness endpoint path
+	LivenessPath = "/healthz"
+	// ReadinessPath : readiness endpoint path
+	ReadinessPath = "/readyz"
+
+	checkTimeout = 5 * time.Second
+	statusOK     = "ok"
+	statusFail   = "fail"
+)
+
+// Check : single health check, returns nil when healthy
+type Check func(ctx context.Context) error
+
+// Checker : liveness and readiness checks registry
+type Checker struct {
+	mu        sync.RWMutex
+	liveness  map[string]Check
+	readiness map[string]Check
+	logger    log.Logger
+}
+
+type checksResp struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// NewChecker : create new health checker
+func NewChecker(logger log.Logger) *Checker {
+	return &Checker{
+		liveness:  map[string]Check{},
+		readiness: map[string]Check{},
+		logger:    logger,
+	}
+}
+
+// AddLivenessCheck : register check that tells whether the process must be restarted
+func (c *Checker) AddLivenessCheck(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.liveness[name] = check
+}
+
+// AddReadinessCheck : register check that tells whether the bot is able to do its job
+func (c *Checker) AddReadinessCheck(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readiness[name] = check
+}
+
+// LivenessHandler : HTTP handler for liveness checks
+func (c *Checker) LivenessHandler() http.Handler {
+	return c.handler(func() map[string]Check { return c.liveness })
+}
+
+// ReadinessHandler : HTTP handler for readiness checks
+func (c *Checker) ReadinessHandler() http.Handler {
+	return c.handler(func() map[string]Check { return c.readiness })
+}
+
+func (c *Checker) handler(checks func() map[string]Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		defer cancel()
+
+		c.mu.RLock()
+		resp := c.run(ctx, checks())
+		c.mu.RUnlock()
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if resp.Status != statusOK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			level.Error(c.logger).Log("msg", "failed to write health response", "err", err)
+		}
+	})
+}
+
+// run : run checks concurrently, checks not finished before ctx is done are reported as failed
+func (c *Checker) run(ctx context.Context, checks map[string]Check) checksResp {
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+
+	results := map[string]string{}
+	names := make([]string, 0, len(checks))
+
+	for name := range checks {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		wg.Add(1)
+
+		go func(name string, check Check) {
+			defer wg.Done()
+
+			result := statusOK
+
+			if err := check(ctx); err != nil {
+				level.Warn(c.logger).Log("msg", "health check failed", "check", name, "err", err)
+				result = fmt.Sprintf("%s: %v", statusFail, err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			results[name] = result
+		}(name, checks[name])
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	resp := checksResp{Status: statusOK, Checks: map[string]string{}}
+
+	for _, name := range names {
+		result, ok := results[name]
+
+		if !ok {
+			level.Warn(c.logger).Log("msg", "health check did not finish", "check", name, "err", ctx.Err())
+			result = fmt.Sprintf("%s: %v", statusFail, ctx.Err())
+		}
+
+		if result != statusOK {
+			resp.Status = statusFail
+		}
+
+		resp.Checks[name] = result
+	}
+
+	return resp
+}
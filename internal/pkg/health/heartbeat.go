@@ -0,0 +1,46 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Heartbeat : last time some periodic work was done
+type Heartbeat struct {
+	mu   sync.RWMutex
+	last time.Time
+}
+
+// NewHeartbeat : create heartbeat, counting from now
+func NewHeartbeat() *Heartbeat {
+	return &Heartbeat{last: time.Now()}
+}
+
+// Beat : record that the work is done right now
+func (h *Heartbeat) Beat() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.last = time.Now()
+}
+
+// Last : get time of the last beat
+func (h *Heartbeat) Last() time.Time {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.last
+}
+
+// Check : health check that fails when the last beat is older than maxAge
+func (h *Heartbeat) Check(maxAge time.Duration) Check {
+	return func(ctx context.Context) error {
+		last := h.Last()
+
+		if age := time.Since(last); age > maxAge {
+			return fmt.Errorf("last beat %s ago at %s", age.Round(time.Second), last.Format(time.RFC3339))
+		}
+
+		return nil
+	}
+}
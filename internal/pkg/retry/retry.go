@@ -0,0 +1,122 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff : exponential backoff settings
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	Factor  float64
+	// Jitter : random part of the delay, from 0 to 1
+	Jitter float64
+}
+
+// DefaultBackoff : backoff used for external dependencies
+var DefaultBackoff = Backoff{
+	Initial: time.Second,
+	Max:     30 * time.Second,
+	Factor:  2,
+	Jitter:  0.2,
+}
+
+// Delay : get delay before the retry attempt, attempts start from 0
+func (b Backoff) Delay(attempt int) time.Duration {
+	delay := float64(b.Initial) * math.Pow(b.Factor, float64(attempt))
+
+	if delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+
+	if b.Jitter > 0 {
+		delay += delay * b.Jitter * (rand.Float64()*2 - 1)
+	}
+
+	return time.Duration(delay)
+}
+
+// Delayer : error requesting the minimum delay before the next attempt, e.g. by HTTP Retry-After header
+type Delayer interface {
+	RetryDelay() time.Duration
+}
+
+// MinDelay : get minimum delay before the next attempt requested by err, 0 when not requested
+func MinDelay(err error) time.Duration {
+	var delayer Delayer
+
+	if errors.As(err, &delayer) {
+		return delayer.RetryDelay()
+	}
+
+	return 0
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent : wrap error to stop retrying
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &permanentError{err: err}
+}
+
+// Do : call fn until it succeeds or ctx is done, returns the last fn error
+//
+// Retrying stops when fn returns an error wrapped by Permanent. notify, if
+// not nil, is called after each failed attempt with the delay before the
+// next one. The delay is not shorter than the one requested by a Delayer error.
+func Do(ctx context.Context, b Backoff, fn func() error, notify func(err error, delay time.Duration)) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+
+		if err == nil {
+			return nil
+		}
+
+		var permanent *permanentError
+
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+
+		delay := b.Delay(attempt)
+
+		if minDelay := MinDelay(err); delay < minDelay {
+			delay = minDelay
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+
+		if notify != nil {
+			notify(err, delay)
+		}
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
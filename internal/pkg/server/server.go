@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+const readHeaderTimeout = 10 * time.Second
+
+// Server : internal HTTP server for health checks and admin endpoints
+type Server struct {
+	mux    *http.ServeMux
+	srv    *http.Server
+	logger log.Logger
+}
+
+// NewServer : create new internal HTTP server
+func NewServer(addr string, logger log.Logger) *Server {
+	mux := http.NewServeMux()
+
+	return &Server{
+		mux: mux,
+		srv: &http.Server{
+			Addr:              addr,
+			Handler:           mux,
+			ReadHeaderTimeout: readHeaderTimeout,
+		},
+		logger: logger,
+	}
+}
+
+// Handle : register handler for the given pattern
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Run : start listening, blocks until the server is shut down
+func (s *Server) Run() error {
+	level.Info(s.logger).Log("msg", "start http server", "addr", s.srv.Addr)
+
+	err := s.srv.ListenAndServe()
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// Shutdown : gracefully stop the server
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
@@ -0,0 +1,79 @@
+package source
+
+import (
+	"sync"
+	"time"
+)
+
+// Resolved alerts are remembered for this long to drop resent resolved notifications, e.g. of Alertmanager HA replicas
+const resolvedAlertTTL = time.Hour
+
+// alertState : last known status of the alert
+type alertState struct {
+	status string
+	// resolved : time the alert was recorded as resolved, zero for other statuses
+	resolved time.Time
+}
+
+// alertStates : last known status of alerts by fingerprint
+//
+// Grafana and Alertmanager resend firing alerts on every group update and
+// repeat interval, only status transitions become annotations.
+type alertStates struct {
+	mu       sync.Mutex
+	statuses map[string]alertState
+}
+
+func newAlertStates() *alertStates {
+	return &alertStates{statuses: map[string]alertState{}}
+}
+
+// changed : whether the alert status differs from the recorded one, the status is not recorded
+//
+// Resolved alerts are reported when they were recorded as firing or are unknown since startup, they may have
+// fired before restart.
+func (s *alertStates) changed(a alert) bool {
+	if a.Fingerprint == "" {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.statuses[a.Fingerprint]
+
+	if !ok || state.expired(time.Now()) {
+		return true
+	}
+
+	return state.status != a.Status
+}
+
+// record : record statuses of the alerts, called when their annotations are queued
+func (s *alertStates) record(alerts []alert) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	for fingerprint, state := range s.statuses {
+		if state.expired(now) {
+			delete(s.statuses, fingerprint)
+		}
+	}
+
+	for _, a := range alerts {
+		switch {
+		case a.Fingerprint == "":
+		case a.Status == alertStatusResolved:
+			s.statuses[a.Fingerprint] = alertState{status: a.Status, resolved: now}
+		default:
+			s.statuses[a.Fingerprint] = alertState{status: a.Status}
+		}
+	}
+}
+
+// expired : resolved record is too old to suppress repeats
+func (state alertState) expired(now time.Time) bool {
+	return !state.resolved.IsZero() && now.Sub(state.resolved) > resolvedAlertTTL
+}
@@ -0,0 +1,247 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/grafana"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/health"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/retry"
+)
+
+const (
+	// Saved cursors older than this are ignored to not flood chats after a long downtime
+	maxCursorAge = 24 * time.Hour
+	// Liveness check fails when the poller has not ticked for interval plus this period
+	livenessGracePeriod = time.Minute
+	// Readiness check fails when scrapes keep failing for this many intervals
+	scrapeFailedIntervals = 5
+)
+
+// GrafanaPollerConfig : Grafana poller config
+type GrafanaPollerConfig struct {
+	Client   *grafana.Client
+	OrgIDs   []int64
+	Interval time.Duration
+	State    *health.Dependency
+	Cursors  CursorStore
+	Logger   log.Logger
+}
+
+// GrafanaPoller : source polling annotations of Grafana instance
+type GrafanaPoller struct {
+	client      *grafana.Client
+	interval    time.Duration
+	orgClients  []*grafana.Client
+	state       *health.Dependency
+	cursors     CursorStore
+	heartbeat   *health.Heartbeat
+	lastSuccess *health.Heartbeat
+	// Scrapes are skipped until this time when Grafana requested a longer delay than the interval
+	retryAfter time.Time
+	logger     log.Logger
+}
+
+// NewGrafanaPoller : create new Grafana poller
+func NewGrafanaPoller(config GrafanaPollerConfig) *GrafanaPoller {
+	// Annotations are per organization, scrape each configured one with its own time window
+	orgClients := []*grafana.Client{config.Client}
+
+	if len(config.OrgIDs) > 0 {
+		orgClients = nil
+
+		for _, orgID := range config.OrgIDs {
+			orgClients = append(orgClients, config.Client.WithOrg(orgID))
+		}
+	}
+
+	return &GrafanaPoller{
+		client:      config.Client,
+		interval:    config.Interval,
+		orgClients:  orgClients,
+		state:       config.State,
+		cursors:     config.Cursors,
+		heartbeat:   health.NewHeartbeat(),
+		lastSuccess: health.NewHeartbeat(),
+		logger:      config.Logger,
+	}
+}
+
+// Name : get source name, the Grafana instance name
+func (p *GrafanaPoller) Name() string {
+	return p.client.Name()
+}
+
+// Alive : fails when the poller is stuck
+func (p *GrafanaPoller) Alive(ctx context.Context) error {
+	return p.heartbeat.Check(p.interval + livenessGracePeriod)(ctx)
+}
+
+// Ready : fails when Grafana is unreachable or scrapes keep failing
+func (p *GrafanaPoller) Ready(ctx context.Context) error {
+	if _, err := p.client.GetStatus(ctx); err != nil {
+		return err
+	}
+
+	if err := p.lastSuccess.Check(scrapeFailedIntervals * p.interval)(ctx); err != nil {
+		return fmt.Errorf("no successful scrape: %w", err)
+	}
+
+	return nil
+}
+
+// Run : scrape annotations until stop is done
+func (p *GrafanaPoller) Run(ctx context.Context, stop context.Context, annotationsChannel chan<- grafana.Annotation) error {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	lastScrapeTimes := map[int64]time.Time{}
+
+	for _, orgClient := range p.orgClients {
+		lastScrapeTimes[orgClient.OrgID()] = p.loadCursor(orgClient.OrgID())
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			p.heartbeat.Beat()
+			currTime := time.Now()
+
+			if currTime.Before(p.retryAfter) {
+				continue
+			}
+
+			scrapeCtx, scrapeCancel := context.WithTimeout(stop, p.interval)
+			// One failure is recorded per scrape, whatever number of organizations failed
+			var scrapeErr error
+
+			for _, orgClient := range p.orgClients {
+				orgID := orgClient.OrgID()
+				annotationsResps, err := p.scrape(scrapeCtx, orgClient, lastScrapeTimes[orgID], currTime)
+
+				if err != nil {
+					level.Error(p.logger).Log("msg", "failed to get annotations", "org_id", orgID, "err", err)
+					scrapeErr = err
+
+					if retryAfter := currTime.Add(retry.MinDelay(err)); retryAfter.After(p.retryAfter) {
+						p.retryAfter = retryAfter
+					}
+
+					// Keep the time window to fetch missed annotations on the next scrape
+					continue
+				}
+
+				for i := len(annotationsResps) - 1; i >= 0; i-- {
+					level.Info(p.logger).Log("msg", "get new annotation", "annotation", annotationsResps[i])
+
+					select {
+					case annotationsChannel <- annotationsResps[i]:
+					case <-ctx.Done():
+						scrapeCancel()
+						return nil
+					}
+				}
+
+				lastScrapeTimes[orgID] = currTime
+				p.saveCursor(orgID, currTime)
+			}
+
+			scrapeCancel()
+
+			if scrapeErr != nil {
+				if p.state.Fail(scrapeErr) {
+					level.Warn(p.logger).Log("msg", "grafana is unavailable, keep retrying", "reason", grafana.Describe(scrapeErr))
+				}
+
+				continue
+			}
+
+			p.lastSuccess.Beat()
+
+			if p.state.Recover() {
+				level.Info(p.logger).Log("msg", "grafana is available again")
+			}
+
+		case <-stop.Done():
+			level.Info(p.logger).Log("msg", "stop grafana poller")
+			return nil
+		}
+	}
+}
+
+// scrape : get annotations, transient errors are retried until ctx is done
+func (p *GrafanaPoller) scrape(ctx context.Context, client *grafana.Client, from time.Time, to time.Time) (grafana.AnnotationsResp, error) {
+	var annotationsResps grafana.AnnotationsResp
+
+	err := retry.Do(ctx, retry.DefaultBackoff, func() error {
+		var err error
+		annotationsResps, err = client.GetAnnotations(ctx, from, to)
+
+		if !grafana.IsRetryable(err) {
+			return retry.Permanent(err)
+		}
+
+		return err
+	}, nil)
+
+	return annotationsResps, err
+}
+
+func (p *GrafanaPoller) cursorName(orgID int64) string {
+	return fmt.Sprintf("grafana/%s/%d", p.client.Name(), orgID)
+}
+
+// loadCursor : get time of the last successful scrape, now when there is no fresh saved cursor
+func (p *GrafanaPoller) loadCursor(orgID int64) time.Time {
+	now := time.Now()
+
+	if p.cursors == nil {
+		return now
+	}
+
+	cursor, err := p.cursors.GetCursor(p.cursorName(orgID))
+
+	if err != nil {
+		level.Error(p.logger).Log("msg", "failed to load cursor", "org_id", orgID, "err", err)
+		return now
+	}
+
+	if cursor == "" {
+		return now
+	}
+
+	millis, err := strconv.ParseInt(cursor, 10, 64)
+
+	if err != nil {
+		level.Error(p.logger).Log("msg", "invalid cursor", "org_id", orgID, "cursor", cursor, "err", err)
+		return now
+	}
+
+	lastScrapeTime := time.UnixMilli(millis)
+
+	if now.Sub(lastScrapeTime) > maxCursorAge {
+		level.Warn(p.logger).Log("msg", "cursor is too old, skip older annotations", "org_id", orgID, "cursor", lastScrapeTime)
+		return now.Add(-maxCursorAge)
+	}
+
+	level.Info(p.logger).Log("msg", "resume scraping from cursor", "org_id", orgID, "cursor", lastScrapeTime)
+
+	return lastScrapeTime
+}
+
+func (p *GrafanaPoller) saveCursor(orgID int64, lastScrapeTime time.Time) {
+	if p.cursors == nil {
+		return
+	}
+
+	err := p.cursors.SaveCursor(p.cursorName(orgID), strconv.FormatInt(lastScrapeTime.UnixMilli(), 10))
+
+	if err != nil && !errors.Is(err, context.Canceled) {
+		level.Error(p.logger).Log("msg", "failed to save cursor", "org_id", orgID, "err", err)
+	}
+}
@@ -0,0 +1,52 @@
+package source
+
+import (
+	"context"
+	"sync"
+
+	"github.com/oklog/run"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/grafana"
+)
+
+// Source : producer of annotations for the delivery pipeline
+type Source interface {
+	// Name : unique source name
+	Name() string
+	// Run : produce annotations until stop is done, canceling ctx aborts sending of produced annotations
+	Run(ctx context.Context, stop context.Context, annotationsChannel chan<- grafana.Annotation) error
+	// Alive : liveness check, fails when the source is stuck
+	Alive(ctx context.Context) error
+	// Ready : readiness check, fails when the source can not produce annotations
+	Ready(ctx context.Context) error
+}
+
+// CursorStore : persists source position between restarts
+type CursorStore interface {
+	// GetCursor : get saved cursor, empty string when there is none
+	GetCursor(name string) (string, error)
+	// SaveCursor : save cursor
+	SaveCursor(name string, cursor string) error
+}
+
+// AddToGroup : run each source as run.Group actor, annotationsChannel is closed after all the sources stop
+func AddToGroup(gr *run.Group, ctx context.Context, sources []Source, annotationsChannel chan<- grafana.Annotation) {
+	var running sync.WaitGroup
+
+	for _, src := range sources {
+		src := src
+		stop, stopSource := context.WithCancel(ctx)
+
+		running.Add(1)
+		gr.Add(func() error {
+			defer running.Done()
+			return src.Run(ctx, stop, annotationsChannel)
+		}, func(err error) {
+			stopSource()
+		})
+	}
+
+	go func() {
+		running.Wait()
+		close(annotationsChannel)
+	}()
+}
@@ -0,0 +1,260 @@
+package source
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/grafana"
+)
+
+const (
+	// WebhookSourceName : name of the webhook source
+	WebhookSourceName = "webhook"
+	// AlertmanagerSourceName : source of annotations received from Alertmanager by webhook
+	AlertmanagerSourceName = "alertmanager"
+
+	// Webhook payload formats, the last path element of the endpoint
+	webhookFormatGrafana      = "grafana"
+	webhookFormatAlertmanager = "alertmanager"
+	webhookFormatEvents       = "events"
+
+	signatureHeader          = "X-Grafana-Alerting-Signature"
+	signatureTimestampHeader = "X-Grafana-Alerting-Signature-Timestamp"
+
+	maxWebhookBodySize = 1 << 20
+	webhookQueueSize   = 32
+
+	// maxSignatureAge : signed requests with older or future timestamps are rejected as replays
+	maxSignatureAge = 5 * time.Minute
+)
+
+var errWebhookUnauthorized = errors.New("unauthorized")
+
+// WebhookConfig : webhook receiver config
+type WebhookConfig struct {
+	// Path : endpoints prefix, formats are served at <Path>/<format>
+	Path       string
+	Token      string
+	HMACSecret string
+	Logger     log.Logger
+}
+
+// Webhook : source receiving annotations by HTTP
+type Webhook struct {
+	path       string
+	token      string
+	hmacSecret []byte
+	queue      chan []grafana.Annotation
+	alerts     *alertStates
+	running    atomic.Bool
+	parsers    map[string]func(body []byte) (webhookBatch, error)
+	logger     log.Logger
+}
+
+// NewWebhook : create new webhook receiver, it must be registered on HTTP server
+func NewWebhook(config WebhookConfig) (*Webhook, error) {
+	if config.Token == "" && config.HMACSecret == "" {
+		return nil, fmt.Errorf("webhook token or hmac secret is required")
+	}
+
+	webhook := &Webhook{
+		path:       strings.TrimSuffix(config.Path, "/"),
+		token:      config.Token,
+		hmacSecret: []byte(config.HMACSecret),
+		queue:      make(chan []grafana.Annotation, webhookQueueSize),
+		alerts:     newAlertStates(),
+		logger:     config.Logger,
+	}
+
+	webhook.parsers = map[string]func(body []byte) (webhookBatch, error){
+		webhookFormatGrafana:      webhook.parseGrafanaAlerts,
+		webhookFormatAlertmanager: webhook.parseAlertmanagerAlerts,
+		webhookFormatEvents:       parseEvents,
+	}
+
+	return webhook, nil
+}
+
+// Path : get endpoints prefix to register on HTTP server
+func (w *Webhook) Path() string {
+	return w.path + "/"
+}
+
+// Name : get source name
+func (w *Webhook) Name() string {
+	return WebhookSourceName
+}
+
+// Alive : webhook is driven by requests, so it is always alive
+func (w *Webhook) Alive(ctx context.Context) error {
+	return nil
+}
+
+// Ready : fails when webhook does not accept annotations
+func (w *Webhook) Ready(ctx context.Context) error {
+	if !w.running.Load() {
+		return fmt.Errorf("webhook is not running")
+	}
+
+	return nil
+}
+
+// Run : forward received annotations until stop is done
+func (w *Webhook) Run(ctx context.Context, stop context.Context, annotationsChannel chan<- grafana.Annotation) error {
+	w.running.Store(true)
+	defer w.running.Store(false)
+
+	for {
+		select {
+		case annotations := <-w.queue:
+			for _, annotation := range annotations {
+				select {
+				case annotationsChannel <- annotation:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+
+		case <-stop.Done():
+			level.Info(w.logger).Log("msg", "stop webhook")
+			return nil
+		}
+	}
+}
+
+// ServeHTTP : receive annotations
+func (w *Webhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	format := strings.TrimPrefix(r.URL.Path, w.Path())
+	parse, ok := w.parsers[format]
+
+	if !ok {
+		http.NotFound(rw, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBodySize))
+
+	if err != nil {
+		http.Error(rw, "could not read body", http.StatusBadRequest)
+		return
+	}
+
+	if err := w.authenticate(r, body); err != nil {
+		level.Warn(w.logger).Log("msg", "webhook request rejected", "remote_addr", r.RemoteAddr, "err", err)
+		http.Error(rw, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// Alert states are recorded only when the whole batch is queued, a rejected request is accepted on retry
+	batch, err := parse(body)
+
+	if err != nil {
+		level.Warn(w.logger).Log("msg", "invalid webhook payload", "format", format, "err", err)
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !w.running.Load() {
+		http.Error(rw, "webhook is not running", http.StatusServiceUnavailable)
+		return
+	}
+
+	for i := range batch.annotations {
+		if batch.annotations[i].Source == "" {
+			batch.annotations[i].Source = WebhookSourceName
+		}
+	}
+
+	if len(batch.annotations) > 0 {
+		// Rejected at once, the sender connection is not held until its timeout
+		select {
+		case w.queue <- batch.annotations:
+		default:
+			level.Warn(w.logger).Log("msg", "webhook request rejected, annotations queue is full", "remote_addr", r.RemoteAddr)
+			http.Error(rw, "annotations queue is full", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	w.alerts.record(batch.alerts)
+
+	for _, annotation := range batch.annotations {
+		level.Info(w.logger).Log("msg", "get new annotation", "format", format, "annotation", annotation)
+	}
+
+	rw.WriteHeader(http.StatusAccepted)
+}
+
+// authenticate : check bearer token or HMAC signature of the body
+func (w *Webhook) authenticate(r *http.Request, body []byte) error {
+	if w.token != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		if subtle.ConstantTimeCompare([]byte(token), []byte(w.token)) == 1 {
+			return nil
+		}
+	}
+
+	if len(w.hmacSecret) > 0 {
+		signature, err := hex.DecodeString(r.Header.Get(signatureHeader))
+
+		if err != nil {
+			return errWebhookUnauthorized
+		}
+
+		mac := hmac.New(sha256.New, w.hmacSecret)
+
+		// Grafana signs "<timestamp>:<body>" when timestamp header is configured
+		if timestamp := r.Header.Get(signatureTimestampHeader); timestamp != "" {
+			if err := checkSignatureTimestamp(timestamp, time.Now()); err != nil {
+				return err
+			}
+
+			mac.Write([]byte(timestamp + ":"))
+		}
+
+		mac.Write(body)
+
+		if hmac.Equal(signature, mac.Sum(nil)) {
+			return nil
+		}
+	}
+
+	return errWebhookUnauthorized
+}
+
+// checkSignatureTimestamp : signature timestamp in Unix seconds must be within maxSignatureAge of now
+func checkSignatureTimestamp(timestamp string, now time.Time) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+
+	if err != nil {
+		return errWebhookUnauthorized
+	}
+
+	age := now.Sub(time.Unix(seconds, 0))
+
+	if age > maxSignatureAge || age < -maxSignatureAge {
+		return fmt.Errorf("%w: signature timestamp is out of %s window", errWebhookUnauthorized, maxSignatureAge)
+	}
+
+	return nil
+}
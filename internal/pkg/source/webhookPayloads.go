@@ -0,0 +1,246 @@
+package source
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/grafana"
+)
+
+const (
+	alertStatusFiring   = "firing"
+	alertStatusResolved = "resolved"
+
+	// Annotation states used by Grafana
+	stateAlerting = "alerting"
+	stateOK       = "ok"
+)
+
+var ruleURLRegexp = regexp.MustCompile(`/alerting/grafana/([^/?#]+)/view`)
+
+// alertsPayload : Grafana unified alerting webhook payload, compatible with Alertmanager
+type alertsPayload struct {
+	Receiver          string            `json:"receiver"`
+	Status            string            `json:"status"`
+	OrgID             int64             `json:"orgId"`
+	Alerts            []alert           `json:"alerts"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+}
+
+type alert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// webhookBatch : annotations of the payload and alerts to record in alert states when the annotations are queued
+type webhookBatch struct {
+	annotations []grafana.Annotation
+	alerts      []alert
+}
+
+// event : generic annotation event
+type event struct {
+	Text      string            `json:"text"`
+	Tags      []string          `json:"tags"`
+	Time      int64             `json:"time"`
+	OrgID     int64             `json:"orgId"`
+	State     string            `json:"state"`
+	PrevState string            `json:"prevState"`
+	Labels    map[string]string `json:"labels"`
+}
+
+// parseGrafanaAlerts : parse Grafana alerting webhook payload, alert states are not changed
+func (w *Webhook) parseGrafanaAlerts(body []byte) (webhookBatch, error) {
+	var (
+		payload alertsPayload
+		batch   webhookBatch
+	)
+
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return batch, err
+	}
+
+	for _, a := range payload.Alerts {
+		annotation, err := alertToAnnotation(a)
+
+		if err != nil {
+			return webhookBatch{}, err
+		}
+
+		if !w.alerts.changed(a) {
+			continue
+		}
+
+		annotation.OrgID = payload.OrgID
+
+		if panelID, err := strconv.Atoi(a.Annotations["__panelId__"]); err == nil {
+			annotation.PanelID = panelID
+		}
+
+		batch.annotations = append(batch.annotations, annotation)
+		batch.alerts = append(batch.alerts, a)
+	}
+
+	return batch, nil
+}
+
+// parseAlertmanagerAlerts : parse Alertmanager webhook payload, alert states are not changed
+func (w *Webhook) parseAlertmanagerAlerts(body []byte) (webhookBatch, error) {
+	var (
+		payload alertsPayload
+		batch   webhookBatch
+	)
+
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return batch, err
+	}
+
+	for _, a := range payload.Alerts {
+		annotation, err := alertToAnnotation(a)
+
+		if err != nil {
+			return webhookBatch{}, err
+		}
+
+		if !w.alerts.changed(a) {
+			continue
+		}
+
+		annotation.Source = AlertmanagerSourceName
+		batch.annotations = append(batch.annotations, annotation)
+		batch.alerts = append(batch.alerts, a)
+	}
+
+	return batch, nil
+}
+
+// alertToAnnotation : convert alert to annotation, labels become "key:value" tags
+func alertToAnnotation(a alert) (grafana.Annotation, error) {
+	annotation := grafana.Annotation{
+		AlertUID: alertRuleUID(a.GeneratorURL),
+		Labels:   a.Labels,
+		Tags:     labelsToTags(a.Labels),
+	}
+
+	switch a.Status {
+	case alertStatusFiring:
+		annotation.NewState = stateAlerting
+		annotation.PrevState = stateOK
+		annotation.Time = a.StartsAt.UnixMilli()
+	case alertStatusResolved:
+		annotation.NewState = stateOK
+		annotation.PrevState = stateAlerting
+		annotation.Time = a.EndsAt.UnixMilli()
+	default:
+		return annotation, fmt.Errorf("unknown alert status %q", a.Status)
+	}
+
+	if annotation.Time <= 0 {
+		annotation.Time = time.Now().UnixMilli()
+	}
+
+	name := a.Labels["alertname"]
+
+	if name == "" {
+		name = "Alert"
+	}
+
+	lines := []string{fmt.Sprintf("[%s] %s", strings.ToUpper(a.Status), name)}
+
+	for _, key := range []string{"summary", "description", "message"} {
+		if value := a.Annotations[key]; value != "" {
+			lines = append(lines, value)
+		}
+	}
+
+	annotation.Text = strings.Join(lines, "\n")
+
+	return annotation, nil
+}
+
+// alertRuleUID : get Grafana alert rule UID from generator URL, e.g. http://grafana/alerting/grafana/<uid>/view
+func alertRuleUID(generatorURL string) string {
+	match := ruleURLRegexp.FindStringSubmatch(generatorURL)
+
+	if match == nil {
+		return ""
+	}
+
+	return match[1]
+}
+
+func labelsToTags(labels map[string]string) []string {
+	tags := make([]string, 0, len(labels))
+
+	for key, value := range labels {
+		// Grafana internal labels
+		if strings.HasPrefix(key, "__") {
+			continue
+		}
+
+		tags = append(tags, key+":"+value)
+	}
+
+	sort.Strings(tags)
+
+	return tags
+}
+
+// parseEvents : parse single event object or list of events
+func parseEvents(body []byte) (webhookBatch, error) {
+	var events []event
+
+	body = bytes.TrimSpace(body)
+
+	if bytes.HasPrefix(body, []byte("[")) {
+		if err := json.Unmarshal(body, &events); err != nil {
+			return webhookBatch{}, err
+		}
+	} else {
+		var e event
+
+		if err := json.Unmarshal(body, &e); err != nil {
+			return webhookBatch{}, err
+		}
+
+		events = []event{e}
+	}
+
+	annotations := make([]grafana.Annotation, 0, len(events))
+
+	for _, e := range events {
+		if e.Text == "" {
+			return webhookBatch{}, fmt.Errorf("event text is required")
+		}
+
+		if e.Time == 0 {
+			e.Time = time.Now().UnixMilli()
+		}
+
+		annotations = append(annotations, grafana.Annotation{
+			OrgID:     e.OrgID,
+			NewState:  e.State,
+			PrevState: e.PrevState,
+			Time:      e.Time,
+			Text:      e.Text,
+			Tags:      e.Tags,
+			Labels:    e.Labels,
+		})
+	}
+
+	return webhookBatch{annotations: annotations}, nil
+}
@@ -0,0 +1,361 @@
+package source
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/grafana"
+)
+
+const (
+	testToken  = "secret-token"
+	testSecret = "hmac-secret"
+)
+
+func newTestWebhook(t *testing.T) *Webhook {
+	t.Helper()
+
+	webhook, err := NewWebhook(WebhookConfig{
+		Path:       "/webhook",
+		Token:      testToken,
+		HMACSecret: testSecret,
+		Logger:     log.NewNopLogger(),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	webhook.running.Store(true)
+
+	return webhook
+}
+
+func webhookRequest(format string, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/webhook/"+format, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+testToken)
+
+	return r
+}
+
+func serve(w *Webhook, r *http.Request) int {
+	rw := httptest.NewRecorder()
+	w.ServeHTTP(rw, r)
+
+	return rw.Code
+}
+
+// queued : annotations queued by the webhook so far
+func queued(w *Webhook) []grafana.Annotation {
+	var annotations []grafana.Annotation
+
+	for {
+		select {
+		case batch := <-w.queue:
+			annotations = append(annotations, batch...)
+		default:
+			return annotations
+		}
+	}
+}
+
+func alertPayload(alerts ...string) string {
+	return `{"orgId": 2, "alerts": [` + strings.Join(alerts, ",") + `]}`
+}
+
+func firingAlert(fingerprint string) string {
+	return `{"status": "firing", "fingerprint": "` + fingerprint + `", "labels": {"alertname": "HighCPU", "instance": "web-1"},
+		"annotations": {"summary": "CPU is high", "__panelId__": "4"}, "startsAt": "2024-05-24T15:00:00Z",
+		"generatorURL": "http://grafana/alerting/grafana/rule-uid/view"}`
+}
+
+func resolvedAlert(fingerprint string) string {
+	return `{"status": "resolved", "fingerprint": "` + fingerprint + `", "labels": {"alertname": "HighCPU", "instance": "web-1"},
+		"endsAt": "2024-05-24T15:10:00Z"}`
+}
+
+func TestWebhookPayloads(t *testing.T) {
+	startsAt := time.Date(2024, 5, 24, 15, 0, 0, 0, time.UTC).UnixMilli()
+	endsAt := time.Date(2024, 5, 24, 15, 10, 0, 0, time.UTC).UnixMilli()
+	labels := map[string]string{"alertname": "HighCPU", "instance": "web-1"}
+
+	tests := []struct {
+		name   string
+		format string
+		body   string
+		status int
+		want   []grafana.Annotation
+	}{
+		{
+			name:   "grafana firing",
+			format: webhookFormatGrafana,
+			body:   alertPayload(firingAlert("a")),
+			status: http.StatusAccepted,
+			want: []grafana.Annotation{{
+				Source:    WebhookSourceName,
+				OrgID:     2,
+				PanelID:   4,
+				AlertUID:  "rule-uid",
+				NewState:  stateAlerting,
+				PrevState: stateOK,
+				Time:      startsAt,
+				Text:      "[FIRING] HighCPU\nCPU is high",
+				Tags:      []string{"alertname:HighCPU", "instance:web-1"},
+				Labels:    labels,
+			}},
+		},
+		{
+			name:   "alertmanager resolved",
+			format: webhookFormatAlertmanager,
+			body:   alertPayload(resolvedAlert("a")),
+			status: http.StatusAccepted,
+			want: []grafana.Annotation{{
+				Source:    AlertmanagerSourceName,
+				NewState:  stateOK,
+				PrevState: stateAlerting,
+				Time:      endsAt,
+				Text:      "[RESOLVED] HighCPU",
+				Tags:      []string{"alertname:HighCPU", "instance:web-1"},
+				Labels:    labels,
+			}},
+		},
+		{
+			name:   "single event",
+			format: webhookFormatEvents,
+			body:   `{"text": "Deployed v1.2.3", "tags": ["deploy"], "time": 1716562800000, "orgId": 3}`,
+			status: http.StatusAccepted,
+			want: []grafana.Annotation{{
+				Source: WebhookSourceName,
+				OrgID:  3,
+				Time:   1716562800000,
+				Text:   "Deployed v1.2.3",
+				Tags:   []string{"deploy"},
+			}},
+		},
+		{
+			name:   "list of events",
+			format: webhookFormatEvents,
+			body:   ` [{"text": "first", "time": 1}, {"text": "second", "time": 2, "state": "alerting"}]`,
+			status: http.StatusAccepted,
+			want: []grafana.Annotation{
+				{Source: WebhookSourceName, Time: 1, Text: "first"},
+				{Source: WebhookSourceName, Time: 2, Text: "second", NewState: "alerting"},
+			},
+		},
+		{
+			name:   "event without text",
+			format: webhookFormatEvents,
+			body:   `[{"text": "first"}, {"tags": ["deploy"]}]`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "unknown alert status",
+			format: webhookFormatGrafana,
+			body:   alertPayload(`{"status": "pending", "fingerprint": "a"}`),
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "invalid JSON",
+			format: webhookFormatAlertmanager,
+			body:   `{"alerts": [`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "unknown format",
+			format: "unknown",
+			body:   `{}`,
+			status: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			webhook := newTestWebhook(t)
+
+			if status := serve(webhook, webhookRequest(test.format, test.body)); status != test.status {
+				t.Fatalf("status %d, want %d", status, test.status)
+			}
+
+			if got := queued(webhook); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("annotations\n%+v\nwant\n%+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWebhookMethod(t *testing.T) {
+	webhook := newTestWebhook(t)
+	r := httptest.NewRequest(http.MethodGet, "/webhook/events", nil)
+
+	if status := serve(webhook, r); status != http.StatusMethodNotAllowed {
+		t.Errorf("status %d, want %d", status, http.StatusMethodNotAllowed)
+	}
+}
+
+func sign(secret string, timestamp string, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+
+	if timestamp != "" {
+		mac.Write([]byte(timestamp + ":"))
+	}
+
+	mac.Write([]byte(body))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookAuthentication(t *testing.T) {
+	const body = `{"text": "Deployed"}`
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name      string
+		headers   map[string]string
+		authorize bool
+	}{
+		{"no credentials", nil, false},
+		{"bearer token", map[string]string{"Authorization": "Bearer " + testToken}, true},
+		{"wrong bearer token", map[string]string{"Authorization": "Bearer wrong"}, false},
+		{"token without bearer", map[string]string{"Authorization": testToken}, true},
+		{"signature", map[string]string{signatureHeader: sign(testSecret, "", body)}, true},
+		{"wrong secret", map[string]string{signatureHeader: sign("wrong", "", body)}, false},
+		{"signature of other body", map[string]string{signatureHeader: sign(testSecret, "", `{"text": "other"}`)}, false},
+		{"invalid signature encoding", map[string]string{signatureHeader: "not hex"}, false},
+		{
+			"signature with timestamp",
+			map[string]string{signatureHeader: sign(testSecret, now, body), signatureTimestampHeader: now},
+			true,
+		},
+		{
+			"timestamp is not signed",
+			map[string]string{signatureHeader: sign(testSecret, "", body), signatureTimestampHeader: now},
+			false,
+		},
+		{
+			"stale timestamp",
+			map[string]string{signatureHeader: sign(testSecret, stale, body), signatureTimestampHeader: stale},
+			false,
+		},
+		{
+			"future timestamp",
+			map[string]string{signatureHeader: sign(testSecret, future, body), signatureTimestampHeader: future},
+			false,
+		},
+		{
+			"invalid timestamp",
+			map[string]string{signatureHeader: sign(testSecret, "yesterday", body), signatureTimestampHeader: "yesterday"},
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			webhook := newTestWebhook(t)
+			r := httptest.NewRequest(http.MethodPost, "/webhook/events", strings.NewReader(body))
+
+			for key, value := range test.headers {
+				r.Header.Set(key, value)
+			}
+
+			want := http.StatusUnauthorized
+
+			if test.authorize {
+				want = http.StatusAccepted
+			}
+
+			if status := serve(webhook, r); status != want {
+				t.Errorf("status %d, want %d", status, want)
+			}
+		})
+	}
+}
+
+func TestWebhookTransitions(t *testing.T) {
+	webhook := newTestWebhook(t)
+
+	steps := []struct {
+		name  string
+		body  string
+		texts []string
+	}{
+		{"firing", alertPayload(firingAlert("a")), []string{"[FIRING] HighCPU\nCPU is high"}},
+		{"repeated firing", alertPayload(firingAlert("a")), nil},
+		{"other alert of the group", alertPayload(firingAlert("a"), firingAlert("b")), []string{"[FIRING] HighCPU\nCPU is high"}},
+		{"resolved", alertPayload(resolvedAlert("a")), []string{"[RESOLVED] HighCPU"}},
+		{"repeated resolved", alertPayload(resolvedAlert("a")), nil},
+		{"unknown resolved", alertPayload(resolvedAlert("c")), []string{"[RESOLVED] HighCPU"}},
+		{"repeated unknown resolved", alertPayload(resolvedAlert("c")), nil},
+		{"firing again", alertPayload(firingAlert("a")), []string{"[FIRING] HighCPU\nCPU is high"}},
+		{"without fingerprint", alertPayload(firingAlert(""), firingAlert("")), []string{"[FIRING] HighCPU\nCPU is high", "[FIRING] HighCPU\nCPU is high"}},
+	}
+
+	for _, step := range steps {
+		if status := serve(webhook, webhookRequest(webhookFormatGrafana, step.body)); status != http.StatusAccepted {
+			t.Fatalf("%s: status %d", step.name, status)
+		}
+
+		var texts []string
+
+		for _, annotation := range queued(webhook) {
+			texts = append(texts, annotation.Text)
+		}
+
+		if !reflect.DeepEqual(texts, step.texts) {
+			t.Errorf("%s: annotations %q, want %q", step.name, texts, step.texts)
+		}
+	}
+}
+
+// TestWebhookRetry : alerts of rejected requests are not recorded, so the sender retry is not a duplicate
+func TestWebhookRetry(t *testing.T) {
+	webhook := newTestWebhook(t)
+	body := alertPayload(firingAlert("a"))
+
+	// Invalid alert after a valid one
+	if status := serve(webhook, webhookRequest(webhookFormatGrafana, alertPayload(firingAlert("a"), `{"status": "unknown"}`))); status != http.StatusBadRequest {
+		t.Fatalf("invalid payload: status %d", status)
+	}
+
+	// Webhook is not running
+	webhook.running.Store(false)
+
+	if status := serve(webhook, webhookRequest(webhookFormatGrafana, body)); status != http.StatusServiceUnavailable {
+		t.Fatalf("not running: status %d", status)
+	}
+
+	webhook.running.Store(true)
+
+	// Full queue, the request is rejected without waiting
+	for i := 0; i < webhookQueueSize; i++ {
+		webhook.queue <- []grafana.Annotation{{}}
+	}
+
+	if status := serve(webhook, webhookRequest(webhookFormatGrafana, body)); status != http.StatusServiceUnavailable {
+		t.Fatalf("full queue: status %d", status)
+	}
+
+	if got := len(queued(webhook)); got != webhookQueueSize {
+		t.Fatalf("full queue: %d annotations queued, want %d", got, webhookQueueSize)
+	}
+
+	// Retry
+	if status := serve(webhook, webhookRequest(webhookFormatGrafana, body)); status != http.StatusAccepted {
+		t.Fatalf("retry: status %d", status)
+	}
+
+	if got := queued(webhook); len(got) != 1 || got[0].NewState != stateAlerting {
+		t.Errorf("retry: annotations %+v, want the firing alert", got)
+	}
+}
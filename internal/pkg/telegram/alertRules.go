@@ -0,0 +1,92 @@
+package telegram
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/grafana"
+)
+
+const alertRuleCacheTTL = 5 * time.Minute
+
+type alertRuleCacheKey struct {
+	instance int
+	orgID    int64
+}
+
+// alertData : alert rule details for template
+type alertData struct {
+	UID         string
+	Title       string
+	Group       string
+	Labels      map[string]string
+	Annotations map[string]string
+	Summary     string
+	Description string
+	RunbookURL  string
+	Severity    string
+	Datasources []string
+}
+
+// alertRule : get details of the annotation alert rule, nil for annotations of other kinds
+func (bot *Bot) alertRule(ctx context.Context, annotation grafana.Annotation) *alertData {
+	if annotation.AlertID == 0 && annotation.AlertUID == "" {
+		return nil
+	}
+
+	instance, ok := bot.instanceForSource(annotation.Source)
+
+	if !ok {
+		return nil
+	}
+
+	key := alertRuleCacheKey{instance: instance, orgID: annotation.OrgID}
+	bot.alertRulesMu.Lock()
+	cache, ok := bot.alertRules[key]
+
+	if !ok {
+		cache = grafana.NewAlertRuleCache(bot.grafana[instance].Client.WithOrg(annotation.OrgID), alertRuleCacheTTL)
+		bot.alertRules[key] = cache
+	}
+
+	bot.alertRulesMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, grafanaRequestTimeout)
+	defer cancel()
+
+	rule, found, err := cache.Get(ctx, annotation.AlertID, annotation.AlertUID)
+
+	if err != nil {
+		level.Warn(bot.logger).Log("msg", "failed to get alert rule", "alert", annotation.AlertID, "uid", annotation.AlertUID, "err", err)
+		return nil
+	}
+
+	if !found {
+		return nil
+	}
+
+	// Labels of the alert instance override labels of the rule
+	labels := map[string]string{}
+
+	for k, v := range rule.Labels {
+		labels[k] = v
+	}
+
+	for k, v := range annotation.Labels {
+		labels[k] = v
+	}
+
+	return &alertData{
+		UID:         rule.UID,
+		Title:       rule.Title,
+		Group:       rule.RuleGroup,
+		Labels:      labels,
+		Annotations: rule.Annotations,
+		Summary:     rule.Annotations["summary"],
+		Description: rule.Annotations["description"],
+		RunbookURL:  rule.Annotations["runbook_url"],
+		Severity:    labels["severity"],
+		Datasources: rule.Datasources,
+	}
+}
@@ -3,44 +3,100 @@ package telegram
 import (
 	"context"
 	"html/template"
+	"sync"
 	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/oklog/run"
+	app "github.com/zt-sv/grafana-annotations-bot/internal/app/grafana-annotations-bot"
 	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/database"
 	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/grafana"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/health"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/templatefuncs"
 	"gopkg.in/telebot.v3"
 )
 
 const (
-	commandStart  = "/start"
-	commandStop   = "/stop"
-	commandStatus = "/status"
+	commandStart        = "/start"
+	commandStop         = "/stop"
+	commandStatus       = "/status"
+	commandSilence      = "/silence"
+	commandLast         = "/last"
+	commandHistory      = "/history"
+	commandSearch       = "/search"
+	commandStats        = "/stats"
+	commandSubscribe    = "/subscribe"
+	commandPreview      = "/preview"
+	commandTestTemplate = "/testtemplate"
+	commandTemplate     = "/template"
+	commandReload       = "/reload"
+
+	heartbeatInterval     = 10 * time.Second
+	grafanaRequestTimeout = 30 * time.Second
 )
 
+// GrafanaInstance : Grafana instance the bot works with
+type GrafanaInstance struct {
+	Name   string
+	Client *grafana.Client
+	State  *health.Dependency
+	// OrgIDs : scraped organizations, the default organization of the user when empty
+	OrgIDs []int64
+}
+
 // BotOptions : telegram bot config
 type BotOptions struct {
-	Addr          string
-	Token         string
-	Store         *database.DbClient
-	Logger        log.Logger
-	Revision      string
-	Template      *template.Template
-	GrafanaClient *grafana.Client
+	Addr     string
+	Token    string
+	Store    *database.DbClient
+	Logger   log.Logger
+	Revision string
+	Template app.TemplateExecutor
+	// Templates : named message templates including the default one, Template is the default one when empty
+	Templates     map[string]app.MessageTemplate
+	ListTemplate  *template.Template
+	StatsTemplate *template.Template
+	Grafana       []GrafanaInstance
 	Admins        []int64
+	Alerting      AlertingOptions
+	Reports       ReportOptions
+	Subscriptions SubscriptionOptions
+	Reload        ReloadOptions
 }
 
 // Bot : telegram bot
 type Bot struct {
-	token         string
-	store         *database.DbClient
-	logger        log.Logger
-	startTime     time.Time
-	tb            *telebot.Bot
-	template      *template.Template
-	grafanaClient *grafana.Client
-	admins        []int64
+	token           string
+	store           *database.DbClient
+	logger          log.Logger
+	startTime       time.Time
+	tb              *telebot.Bot
+	listTemplate    *template.Template
+	statsTemplate   *template.Template
+	grafana         []GrafanaInstance
+	admins          []int64
+	heartbeat       *health.Heartbeat
+	alerting        AlertingOptions
+	storeState      *health.Dependency
+	delivery        deliveryStats
+	silences        *expiringStore[silenceTarget]
+	alertRules      map[alertRuleCacheKey]*grafana.AlertRuleCache
+	alertRulesMu    sync.Mutex
+	histories       *expiringStore[historyResult]
+	wizards         *expiringStore[*subscribeWizard]
+	wizardQuestions *expiringStore[string]
+	reports         ReportOptions
+	subscriptions   SubscriptionOptions
+	lastMatched     map[string]time.Time
+	templates       *templateSet
+	reload          ReloadOptions
+	reloadMu        sync.Mutex
+	// Time of the last template report by the report kind and template name
+	templateErrorsReported map[string]time.Time
+	templateErrorsMu       sync.Mutex
+	// Subscription states are read, updated and saved under the lock
+	subscriptionStateMu sync.Mutex
 }
 
 // NewBot : create new telegram bot
@@ -55,27 +111,120 @@ func NewBot(options BotOptions) (*Bot, error) {
 	}
 
 	tgBot := &Bot{
-		admins:        options.Admins,
-		token:         options.Token,
-		logger:        options.Logger,
-		startTime:     time.Now(),
-		template:      options.Template,
-		tb:            bot,
-		store:         options.Store,
-		grafanaClient: options.GrafanaClient,
+		admins:          options.Admins,
+		token:           options.Token,
+		logger:          options.Logger,
+		startTime:       time.Now(),
+		listTemplate:    options.ListTemplate,
+		statsTemplate:   options.StatsTemplate,
+		tb:              bot,
+		store:           options.Store,
+		grafana:         options.Grafana,
+		heartbeat:       health.NewHeartbeat(),
+		alerting:        options.Alerting,
+		storeState:      health.NewDependency(),
+		silences:        newExpiringStore[silenceTarget](silenceTargetTTL),
+		alertRules:      map[alertRuleCacheKey]*grafana.AlertRuleCache{},
+		histories:       newExpiringStore[historyResult](historyTTL),
+		wizards:         newExpiringStore[*subscribeWizard](subscribeWizardTTL),
+		wizardQuestions: newExpiringStore[string](subscribeWizardTTL),
+		reports:         options.Reports,
+		subscriptions:   options.Subscriptions,
+		lastMatched:     map[string]time.Time{},
+		templates:       newTemplateSet(options.Templates),
+		reload:          options.Reload,
+
+		templateErrorsReported: map[string]time.Time{},
+	}
+
+	if options.Templates == nil {
+		tgBot.templates = newTemplateSet(map[string]app.MessageTemplate{
+			app.DefaultTemplateName: {
+				Name:     app.DefaultTemplateName,
+				Template: options.Template,
+				Options:  app.MessageOptions{ParseMode: app.ParseModeHTML},
+			},
+		})
+	}
+
+	if tgBot.listTemplate == nil {
+		tgBot.listTemplate = template.Must(template.New("list").Funcs(templatefuncs.FuncMap()).Parse(DefaultListTemplate))
+	}
+
+	if tgBot.statsTemplate == nil {
+		tgBot.statsTemplate = template.Must(template.New("stats").Funcs(templatefuncs.FuncMap()).Parse(DefaultStatsTemplate))
 	}
 
 	return tgBot, nil
 }
 
+// Ping : check that Telegram API is reachable and the token is valid
+func (bot *Bot) Ping(ctx context.Context) error {
+	errCh := make(chan error, 1)
+
+	go func() {
+		_, err := bot.tb.Raw("getMe", nil)
+		errCh <- err
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Heartbeat : annotations listener heartbeat, beats at least every heartbeatInterval
+func (bot *Bot) Heartbeat() *health.Heartbeat {
+	return bot.heartbeat
+}
+
 // Run : start telegram bot
+//
+// Run returns after annotationsChannel is closed and drained. Canceling ctx
+// aborts the delivery of the pending annotations.
 func (bot *Bot) Run(ctx context.Context, annotationsChannel <-chan grafana.Annotation) error {
 	var gr run.Group
+	{
+		stop := make(chan struct{})
+		gr.Add(func() error {
+			return bot.watchPipeline(stop)
+		}, func(err error) {
+			close(stop)
+		})
+	}
+	{
+		stop := make(chan struct{})
+		gr.Add(func() error {
+			return bot.sendReports(stop)
+		}, func(err error) {
+			close(stop)
+		})
+	}
+	{
+		stop := make(chan struct{})
+		gr.Add(func() error {
+			return bot.checkSubscriptions(stop)
+		}, func(err error) {
+			close(stop)
+		})
+	}
+	{
+		stop := make(chan struct{})
+		gr.Add(func() error {
+			return bot.watchTemplates(stop)
+		}, func(err error) {
+			close(stop)
+		})
+	}
 	{
 		gr.Add(func() error {
 			return bot.listenAnnotations(ctx, annotationsChannel)
 		}, func(err error) {
-			level.Error(bot.logger).Log("msg", "listen annotations error", "err", err)
+			if err != nil {
+				level.Error(bot.logger).Log("msg", "listen annotations error", "err", err)
+			}
 		})
 	}
 	{
@@ -85,10 +234,29 @@ func (bot *Bot) Run(ctx context.Context, annotationsChannel <-chan grafana.Annot
 			bot.tb.Handle(commandStart, bot.onlyForAdmins(bot.handleStart))
 			bot.tb.Handle(commandStatus, bot.onlyForAdmins(bot.handleStatus))
 			bot.tb.Handle(commandStop, bot.onlyForAdmins(bot.handleStop))
+			bot.tb.Handle(commandSilence, bot.onlyForAdmins(bot.handleSilence))
+			bot.tb.Handle(commandLast, bot.onlyForAdmins(bot.handleLast))
+			bot.tb.Handle(commandHistory, bot.onlyForAdmins(bot.handleHistory))
+			bot.tb.Handle(commandSearch, bot.onlyForAdmins(bot.handleSearch))
+			bot.tb.Handle(commandStats, bot.onlyForAdmins(bot.handleStats))
+			bot.tb.Handle(commandSubscribe, bot.onlyForAdmins(bot.handleSubscribe))
+			bot.tb.Handle(commandPreview, bot.onlyForAdmins(bot.handlePreview))
+			bot.tb.Handle(commandTestTemplate, bot.onlyForAdmins(bot.handleTestTemplate))
+			bot.tb.Handle(commandTemplate, bot.onlyForAdmins(bot.handleTemplate))
+			bot.tb.Handle(commandReload, bot.onlyForAdmins(bot.handleReload))
+			bot.tb.Handle(telebot.OnQuery, bot.handleInlineQuery)
+			bot.tb.Handle(telebot.OnText, bot.handleWizardReply)
+			bot.tb.Handle(&telebot.Btn{Unique: buttonSilence}, bot.onlyForAdminsCallback(bot.handleSilenceButton))
+			bot.tb.Handle(&telebot.Btn{Unique: buttonUnsilence}, bot.onlyForAdminsCallback(bot.handleUnsilenceButton))
+			bot.tb.Handle(&telebot.Btn{Unique: buttonHistoryPage}, bot.onlyForAdminsCallback(bot.handleHistoryPageButton))
+			bot.tb.Handle(&telebot.Btn{Unique: buttonSubscribe}, bot.onlyForAdminsCallback(bot.handleSubscribeButton))
 
 			bot.tb.Start()
 			return nil
-		}, func(err error) {})
+		}, func(err error) {
+			level.Info(bot.logger).Log("msg", "stop telegram bot")
+			bot.tb.Stop()
+		})
 	}
 
 	return gr.Run()
@@ -114,6 +282,17 @@ func (bot *Bot) onlyForAdmins(handler func(m *telebot.Message) error) func(teleb
 	}
 }
 
+func (bot *Bot) onlyForAdminsCallback(handler func(c telebot.Context) error) func(telebot.Context) error {
+	return func(c telebot.Context) error {
+		if !bot.isAdminID(c.Sender().ID) {
+			level.Error(bot.logger).Log("msg", "Receive callback from not admin user")
+			return c.Respond(&telebot.CallbackResponse{Text: "Permission denied"})
+		}
+
+		return handler(c)
+	}
+}
+
 // isAdminID returns whether id is one of the configured admin IDs.
 func (bot *Bot) isAdminID(id int64) bool {
 	for _, adminID := range bot.admins {
@@ -0,0 +1,69 @@
+package telegram
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+type expiringItem[T any] struct {
+	value   T
+	created time.Time
+}
+
+// expiringStore : in-memory values referenced by inline buttons
+//
+// Telegram limits callback data to 64 bytes, so buttons carry a short key only.
+// Buttons stop working when the value expires or the bot restarts.
+type expiringStore[T any] struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	items map[string]expiringItem[T]
+}
+
+func newExpiringStore[T any](ttl time.Duration) *expiringStore[T] {
+	return &expiringStore[T]{ttl: ttl, items: map[string]expiringItem[T]{}}
+}
+
+func (s *expiringStore[T]) put(key string, value T) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, item := range s.items {
+		if time.Since(item.created) > s.ttl {
+			delete(s.items, k)
+		}
+	}
+
+	s.items[key] = expiringItem[T]{value: value, created: time.Now()}
+}
+
+func (s *expiringStore[T]) get(key string) (T, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.items[key]
+
+	if !ok || time.Since(item.created) > s.ttl {
+		var zero T
+		return zero, false
+	}
+
+	return item.value, true
+}
+
+func (s *expiringStore[T]) delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.items, key)
+}
+
+// randomKey : random key for expiringStore
+func randomKey() string {
+	key := make([]byte, 8)
+	rand.Read(key)
+
+	return hex.EncodeToString(key)
+}
@@ -0,0 +1,179 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/database"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/grafana"
+)
+
+const (
+	filterKeyTags   = "tags"
+	filterKeyOrg    = "org"
+	filterKeySource = "source"
+)
+
+// annotationFilter : annotations filter from command payload
+//
+// Payload is a whitespace separated list of key=value pairs, e.g.
+// "source=prod org=3 tags=deploy,prod". A value without key is a tags list.
+// Payload without any known key is a comma separated tags list, e.g. "env=prod,deploy",
+// the tags may contain spaces.
+type annotationFilter struct {
+	Tags   []string
+	OrgID  int64
+	Source string
+}
+
+func parseFilter(payload string) (annotationFilter, error) {
+	filter := annotationFilter{}
+
+	if !hasFilterKey(payload) {
+		for _, tag := range strings.Split(payload, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				filter.Tags = append(filter.Tags, tag)
+			}
+		}
+
+		return filter, nil
+	}
+
+	for _, field := range strings.Fields(payload) {
+		key, value, found := strings.Cut(field, "=")
+
+		if !found {
+			key, value = filterKeyTags, field
+		}
+
+		switch key {
+		case filterKeyTags:
+			for _, tag := range strings.Split(value, ",") {
+				if tag != "" {
+					filter.Tags = append(filter.Tags, tag)
+				}
+			}
+
+		case filterKeyOrg:
+			orgID, err := strconv.ParseInt(value, 10, 64)
+
+			if err != nil {
+				return filter, fmt.Errorf("invalid org %q, must be a number", value)
+			}
+
+			filter.OrgID = orgID
+
+		case filterKeySource:
+			filter.Source = value
+
+		default:
+			return filter, fmt.Errorf("unknown filter %q", key)
+		}
+	}
+
+	return filter, nil
+}
+
+// hasFilterKey : whether the payload has a field starting with known key
+func hasFilterKey(payload string) bool {
+	for _, field := range strings.Fields(payload) {
+		key, _, found := strings.Cut(field, "=")
+
+		if found && (key == filterKeyTags || key == filterKeyOrg || key == filterKeySource) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseFilter : parse the filter and check the organization is scraped
+func (bot *Bot) parseFilter(payload string) (annotationFilter, error) {
+	filter, err := parseFilter(payload)
+
+	if err != nil || filter.OrgID == 0 {
+		return filter, err
+	}
+
+	var (
+		orgIDs        []int64
+		grafanaSource bool
+	)
+
+	for _, instance := range bot.grafana {
+		if filter.Source == "" || filter.Source == instance.Name {
+			orgIDs = append(orgIDs, instance.OrgIDs...)
+			grafanaSource = true
+		}
+	}
+
+	// Organizations of other sources, e.g. webhooks, are not known in advance
+	if !grafanaSource {
+		return filter, nil
+	}
+
+	for _, orgID := range orgIDs {
+		if orgID == filter.OrgID {
+			return filter, nil
+		}
+	}
+
+	if len(orgIDs) == 0 {
+		return filter, fmt.Errorf("org %d is not scraped, no organizations are configured", filter.OrgID)
+	}
+
+	return filter, fmt.Errorf("org %d is not scraped, must be one of %s", filter.OrgID, formatOrgIDs(orgIDs))
+}
+
+func formatOrgIDs(orgIDs []int64) string {
+	parts := make([]string, 0, len(orgIDs))
+
+	for _, orgID := range orgIDs {
+		parts = append(parts, strconv.FormatInt(orgID, 10))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func (f annotationFilter) isEmpty() bool {
+	return len(f.Tags) == 0 && f.OrgID == 0 && f.Source == ""
+}
+
+func (f annotationFilter) matches(annotation grafana.Annotation) bool {
+	if f.Source != "" && f.Source != annotation.Source {
+		return false
+	}
+
+	if f.OrgID != 0 && f.OrgID != annotation.OrgID {
+		return false
+	}
+
+	return allTagsExist(annotation.Tags, f.Tags)
+}
+
+func (f annotationFilter) String() string {
+	var parts []string
+
+	if f.Source != "" {
+		parts = append(parts, fmt.Sprintf("%s=%s", filterKeySource, f.Source))
+	}
+
+	if f.OrgID != 0 {
+		parts = append(parts, fmt.Sprintf("%s=%d", filterKeyOrg, f.OrgID))
+	}
+
+	if len(f.Tags) > 0 {
+		parts = append(parts, fmt.Sprintf("%s=%s", filterKeyTags, strings.Join(f.Tags, ",")))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func filterFromStoreValue(value database.StoreValue) annotationFilter {
+	return annotationFilter{
+		Tags:   value.Tags,
+		OrgID:  value.OrgID,
+		Source: value.Source,
+	}
+}
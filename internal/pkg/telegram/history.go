@@ -0,0 +1,378 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/grafana"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/telegramhtml"
+	"gopkg.in/telebot.v3"
+)
+
+const (
+	buttonHistoryPage = "history_page"
+
+	defaultLastCount      = 10
+	maxHistoryAnnotations = 500
+	historyPageSize       = 10
+	// Annotations requested from Grafana at once
+	historyFetchLimit = 100
+	// Annotations checked in one Grafana instance at most
+	historyScanLimit = 5000
+	historyTTL       = time.Hour
+)
+
+// DefaultListTemplate : template of annotation lists, e.g. /last and /history
+const DefaultListTemplate = `<b>{{.Title}}</b>
+{{range .Items}}
+• {{.FormattedDate}}: <b>{{.Title}}</b>{{if .URL}} <a href="{{.URL}}">dashboard</a>{{end}}{{if .Tags}} [{{range $i, $tag := .Tags}}{{if $i}}, {{end}}{{$tag}}{{end}}]{{end}}{{else}}
+No annotations{{end}}{{if gt .Pages 1}}
+
+Page {{.Page}} of {{.Pages}}, {{.Total}} annotations{{end}}`
+
+// annotationSearch : filter of the subscription and text, which annotation text or tags contain ignoring case
+type annotationSearch struct {
+	filter annotationFilter
+	text   string
+	// since : annotations older than this are skipped, zero to not limit
+	since time.Time
+}
+
+func (s annotationSearch) matches(annotation grafana.Annotation) bool {
+	if !s.filter.matches(annotation) {
+		return false
+	}
+
+	if !s.since.IsZero() && annotation.Time < s.since.UnixMilli() {
+		return false
+	}
+
+	if s.text == "" {
+		return true
+	}
+
+	text := strings.ToLower(s.text)
+
+	if strings.Contains(strings.ToLower(annotation.Text), text) {
+		return true
+	}
+
+	for _, tag := range annotation.Tags {
+		if strings.Contains(strings.ToLower(tag), text) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// historyResult : annotations found by /last or /history, pages are switched by buttons
+type historyResult struct {
+	title       string
+	annotations []grafana.Annotation
+}
+
+// listData : list template data
+type listData struct {
+	Title string
+	Total int
+	Page  int
+	Pages int
+	Items []*templateData
+}
+
+// handleLast : /last [n] [filter]
+func (bot *Bot) handleLast(m *telebot.Message) error {
+	count := defaultLastCount
+	payload := strings.Fields(m.Payload)
+
+	if len(payload) > 0 {
+		if n, err := strconv.Atoi(payload[0]); err == nil {
+			if n < 1 || n > maxHistoryAnnotations {
+				return bot.replyHistoryUsage(m, fmt.Errorf("number of annotations must be from 1 to %d", maxHistoryAnnotations))
+			}
+
+			count = n
+			payload = payload[1:]
+		}
+	}
+
+	filter, err := bot.parseFilter(strings.Join(payload, " "))
+
+	if err != nil {
+		return bot.replyHistoryUsage(m, err)
+	}
+
+	title := fmt.Sprintf("Last %d annotations", count)
+
+	return bot.sendHistory(m, title, annotationSearch{filter: filter}, time.Time{}, time.Time{}, count)
+}
+
+// handleHistory : /history <from> <to> [filter]
+func (bot *Bot) handleHistory(m *telebot.Message) error {
+	payload := strings.Fields(m.Payload)
+
+	if len(payload) < 2 {
+		return bot.replyHistoryUsage(m, fmt.Errorf("time range is required"))
+	}
+
+	now := time.Now()
+	from, err := parseTimeArg(payload[0], now)
+
+	if err != nil {
+		return bot.replyHistoryUsage(m, err)
+	}
+
+	to, err := parseTimeArg(payload[1], now)
+
+	if err != nil {
+		return bot.replyHistoryUsage(m, err)
+	}
+
+	if !from.Before(to) {
+		return bot.replyHistoryUsage(m, fmt.Errorf("from must be before to"))
+	}
+
+	filter, err := bot.parseFilter(strings.Join(payload[2:], " "))
+
+	if err != nil {
+		return bot.replyHistoryUsage(m, err)
+	}
+
+	title := fmt.Sprintf("Annotations from %s to %s", from.Format(time.RFC1123), to.Format(time.RFC1123))
+
+	return bot.sendHistory(m, title, annotationSearch{filter: filter}, from, to, maxHistoryAnnotations)
+}
+
+func (bot *Bot) replyHistoryUsage(m *telebot.Message, err error) error {
+	_, err = bot.tb.Send(
+		m.Chat,
+		fmt.Sprintf("Invalid request: %v\n\nExample:\n/last 20 tags=deploy\n/history 7d now source=prod\n/history 2024-05-24T10:00 2024-05-24T18:00", err),
+		&telebot.SendOptions{ThreadID: m.ThreadID},
+	)
+
+	return err
+}
+
+func (bot *Bot) sendHistory(m *telebot.Message, title string, search annotationSearch, from time.Time, to time.Time, count int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), grafanaRequestTimeout)
+	defer cancel()
+
+	if !search.filter.isEmpty() {
+		title = fmt.Sprintf("%s, %s", title, search.filter)
+	}
+
+	annotations, unavailable := bot.findAnnotations(ctx, search, from, to, count)
+
+	if len(unavailable) > 0 {
+		title = fmt.Sprintf("%s\nUnavailable: %s", title, strings.Join(unavailable, ", "))
+	}
+
+	result := historyResult{title: title, annotations: annotations}
+	key := ""
+
+	if len(annotations) > historyPageSize {
+		key = randomKey()
+		bot.histories.put(key, result)
+	}
+
+	text, markup, err := bot.renderHistoryPage(result, key, 1)
+
+	if err != nil {
+		level.Error(bot.logger).Log("msg", "failed to render annotations list", "err", err)
+		_, err = bot.tb.Send(m.Chat, "Failed to render annotations list: "+err.Error(), &telebot.SendOptions{ThreadID: m.ThreadID})
+		return err
+	}
+
+	_, err = bot.sendHTML(m.Chat, text, &telebot.SendOptions{ThreadID: m.ThreadID, ReplyMarkup: markup})
+
+	return err
+}
+
+func (bot *Bot) handleHistoryPageButton(c telebot.Context) error {
+	args := c.Args()
+
+	if len(args) != 2 {
+		return c.Respond(&telebot.CallbackResponse{Text: "Bad page button"})
+	}
+
+	result, ok := bot.histories.get(args[0])
+
+	if !ok {
+		return c.Respond(&telebot.CallbackResponse{Text: "The list expired, request it again", ShowAlert: true})
+	}
+
+	page, err := strconv.Atoi(args[1])
+
+	if err != nil {
+		return c.Respond(&telebot.CallbackResponse{Text: "Bad page button"})
+	}
+
+	text, markup, err := bot.renderHistoryPage(result, args[0], page)
+
+	if err != nil {
+		level.Error(bot.logger).Log("msg", "failed to render annotations list", "err", err)
+		return c.Respond(&telebot.CallbackResponse{Text: "Failed to render annotations list", ShowAlert: true})
+	}
+
+	if err := c.Respond(); err != nil {
+		level.Warn(bot.logger).Log("msg", "failed to answer callback", "err", err)
+	}
+
+	// Edited message can't be split, pages are short
+	return c.Edit(telegramhtml.Sanitize(text), &telebot.SendOptions{ParseMode: telebot.ModeHTML, ReplyMarkup: markup})
+}
+
+// renderHistoryPage : render page of the list with navigation buttons, pages start from 1
+func (bot *Bot) renderHistoryPage(result historyResult, key string, page int) (string, *telebot.ReplyMarkup, error) {
+	pages := (len(result.annotations) + historyPageSize - 1) / historyPageSize
+
+	if pages < 1 {
+		pages = 1
+	}
+
+	if page < 1 {
+		page = 1
+	}
+
+	if page > pages {
+		page = pages
+	}
+
+	start := (page - 1) * historyPageSize
+	end := start + historyPageSize
+
+	if end > len(result.annotations) {
+		end = len(result.annotations)
+	}
+
+	data := listData{
+		Title: result.title,
+		Total: len(result.annotations),
+		Page:  page,
+		Pages: pages,
+	}
+
+	for _, annotation := range result.annotations[start:end] {
+		item := newTemplateData(annotation)
+		item.URL = bot.annotationURL(annotation)
+		data.Items = append(data.Items, item)
+	}
+
+	var text bytes.Buffer
+
+	if err := bot.listTemplate.Execute(&text, &data); err != nil {
+		return "", nil, err
+	}
+
+	if key == "" || pages == 1 {
+		return text.String(), nil, nil
+	}
+
+	markup := &telebot.ReplyMarkup{}
+	var buttons []telebot.Btn
+
+	if page > 1 {
+		buttons = append(buttons, markup.Data("‹ Prev", buttonHistoryPage, key, strconv.Itoa(page-1)))
+	}
+
+	if page < pages {
+		buttons = append(buttons, markup.Data("Next ›", buttonHistoryPage, key, strconv.Itoa(page+1)))
+	}
+
+	markup.Inline(markup.Row(buttons...))
+
+	return text.String(), markup, nil
+}
+
+// findAnnotations : find up to count newest matching annotations in all Grafana instances
+//
+// Returns names of the instances, which failed to answer.
+func (bot *Bot) findAnnotations(ctx context.Context, search annotationSearch, from time.Time, to time.Time, count int) ([]grafana.Annotation, []string) {
+	var (
+		annotations []grafana.Annotation
+		unavailable []string
+	)
+
+	for _, instance := range bot.grafana {
+		if search.filter.Source != "" && search.filter.Source != instance.Name {
+			continue
+		}
+
+		found, err := findInstanceAnnotations(ctx, instance.Client.WithOrg(search.filter.OrgID), search, from, to, count)
+
+		if err != nil {
+			level.Warn(bot.logger).Log("msg", "failed to find annotations", "grafana", instance.Name, "err", err)
+			unavailable = append(unavailable, instance.Name)
+		}
+
+		annotations = append(annotations, found...)
+	}
+
+	sort.SliceStable(annotations, func(i, j int) bool {
+		return annotations[i].Time > annotations[j].Time
+	})
+
+	if len(annotations) > count {
+		annotations = annotations[:count]
+	}
+
+	return annotations, unavailable
+}
+
+// findInstanceAnnotations : page through Grafana annotations from the newest ones
+//
+// Grafana applies the time range only when both bounds are set, so the unset bounds default to the whole time.
+func findInstanceAnnotations(ctx context.Context, client *grafana.Client, search annotationSearch, from time.Time, to time.Time, count int) ([]grafana.Annotation, error) {
+	if from.IsZero() {
+		from = time.UnixMilli(1)
+	}
+
+	if to.IsZero() {
+		to = time.Now()
+	}
+
+	var (
+		annotations []grafana.Annotation
+		seen        = map[int]bool{}
+		query       = grafana.AnnotationsQuery{From: from, To: to, Limit: historyFetchLimit, Tags: search.filter.Tags}
+	)
+
+	for len(annotations) < count && len(seen) < historyScanLimit {
+		page, err := client.FindAnnotations(ctx, query)
+
+		if err != nil {
+			return annotations, err
+		}
+
+		added := 0
+
+		for _, annotation := range page {
+			if seen[annotation.ID] {
+				continue
+			}
+
+			seen[annotation.ID] = true
+			added++
+
+			if search.matches(annotation) {
+				annotations = append(annotations, annotation)
+			}
+		}
+
+		// Annotations of the same millisecond may span pages, so the next page starts from the oldest time
+		if len(page) < query.Limit || added == 0 {
+			break
+		}
+
+		query.To = time.UnixMilli(page[len(page)-1].Time)
+	}
+
+	return annotations, nil
+}
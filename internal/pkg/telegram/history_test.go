@@ -0,0 +1,162 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/grafana"
+)
+
+// newFakeGrafana : Grafana annotations API serving the annotations, newest first
+//
+// Like Grafana, the time range is applied only when both from and to are greater than 0.
+func newFakeGrafana(t *testing.T, annotations []grafana.Annotation) *grafana.Client {
+	t.Helper()
+
+	sorted := append([]grafana.Annotation{}, annotations...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time > sorted[j].Time })
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		from, _ := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+		to, _ := strconv.ParseInt(r.URL.Query().Get("to"), 10, 64)
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+
+		if err != nil {
+			limit = 100
+		}
+
+		page := []grafana.Annotation{}
+
+		for _, annotation := range sorted {
+			if from > 0 && to > 0 && (annotation.Time < from || annotation.Time > to) {
+				continue
+			}
+
+			if len(page) < limit {
+				page = append(page, annotation)
+			}
+		}
+
+		json.NewEncoder(w).Encode(page)
+	}))
+	t.Cleanup(server.Close)
+
+	grafanaURL, _ := url.Parse(server.URL)
+	client, err := grafana.NewClient(grafana.ClientConfig{
+		Name:        "test",
+		URL:         grafanaURL,
+		Token:       "token",
+		TLSInsecure: true,
+		Timeout:     time.Second,
+		Logger:      log.NewNopLogger(),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+// testAnnotations : annotations one minute apart ending now, the newest first
+func testAnnotations(n int) []grafana.Annotation {
+	now := time.Now()
+	annotations := make([]grafana.Annotation, 0, n)
+
+	for i := 0; i < n; i++ {
+		annotations = append(annotations, grafana.Annotation{
+			ID:   i + 1,
+			Time: now.Add(-time.Duration(i) * time.Minute).UnixMilli(),
+			Text: "annotation " + strconv.Itoa(i+1),
+		})
+	}
+
+	return annotations
+}
+
+func TestFindInstanceAnnotationsPaging(t *testing.T) {
+	annotations := testAnnotations(3*historyFetchLimit + 10)
+	client := newFakeGrafana(t, annotations)
+
+	tests := []struct {
+		name  string
+		from  time.Time
+		to    time.Time
+		count int
+		want  int
+	}{
+		{name: "unset bounds", count: 2*historyFetchLimit + 5, want: 2*historyFetchLimit + 5},
+		{name: "all", count: len(annotations), want: len(annotations)},
+		{
+			name:  "time range",
+			from:  time.UnixMilli(annotations[2*historyFetchLimit].Time),
+			to:    time.UnixMilli(annotations[10].Time),
+			count: maxHistoryAnnotations,
+			want:  2*historyFetchLimit - 10 + 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			found, err := findInstanceAnnotations(context.Background(), client, annotationSearch{}, test.from, test.to, test.count)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The last page is not trimmed to the count
+			if len(found) < test.want || len(found) > test.want+historyFetchLimit {
+				t.Fatalf("found %d annotations, want %d", len(found), test.want)
+			}
+
+			seen := map[int]bool{}
+
+			for i, annotation := range found {
+				if seen[annotation.ID] {
+					t.Fatalf("annotation %d is found twice", annotation.ID)
+				}
+
+				seen[annotation.ID] = true
+
+				if i > 0 && annotation.Time > found[i-1].Time {
+					t.Fatalf("annotation %d is newer than the previous one", annotation.ID)
+				}
+
+				if !test.from.IsZero() && (annotation.Time < test.from.UnixMilli() || annotation.Time > test.to.UnixMilli()) {
+					t.Fatalf("annotation %d is out of the time range", annotation.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestAnnotationSearchMatches(t *testing.T) {
+	now := time.Now()
+	annotation := grafana.Annotation{Time: now.Add(-time.Hour).UnixMilli(), Text: "Deploy v1.2.3", Tags: []string{"prod"}}
+
+	tests := []struct {
+		name   string
+		search annotationSearch
+		want   bool
+	}{
+		{name: "text", search: annotationSearch{text: "deploy"}, want: true},
+		{name: "tag", search: annotationSearch{text: "PROD"}, want: true},
+		{name: "other text", search: annotationSearch{text: "rollback"}, want: false},
+		{name: "since before", search: annotationSearch{text: "deploy", since: now.Add(-2 * time.Hour)}, want: true},
+		{name: "since after", search: annotationSearch{text: "deploy", since: now.Add(-time.Minute)}, want: false},
+	}
+
+	for _, test := range tests {
+		if got := test.search.matches(annotation); got != test.want {
+			t.Errorf("%s: matches %v, want %v", test.name, got, test.want)
+		}
+	}
+}
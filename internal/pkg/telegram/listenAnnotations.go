@@ -1,20 +1,38 @@
 package telegram
 
 import (
-	"bytes"
 	"context"
 	"strings"
 	"time"
 
 	"github.com/go-kit/kit/log/level"
+	app "github.com/zt-sv/grafana-annotations-bot/internal/app/grafana-annotations-bot"
 	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/grafana"
 	"gopkg.in/telebot.v3"
 )
 
 type templateData struct {
+	Source    string
+	OrgID     int64
 	Text      string
 	Tags      []string
 	Timestamp int64
+	State     string
+	PrevState string
+	Alert     *alertData
+	URL       string
+}
+
+func newTemplateData(annotation grafana.Annotation) *templateData {
+	return &templateData{
+		Source:    annotation.Source,
+		OrgID:     annotation.OrgID,
+		Text:      annotation.Text,
+		Tags:      annotation.Tags,
+		Timestamp: annotation.Time,
+		State:     annotation.NewState,
+		PrevState: annotation.PrevState,
+	}
 }
 
 // Title : get formatted title for template
@@ -38,6 +56,17 @@ func (t *templateData) FormattedDate() string {
 	return date.Format(time.RFC1123)
 }
 
+// annotationURL : dashboard link of the annotation, empty when it is unknown
+func (bot *Bot) annotationURL(annotation grafana.Annotation) string {
+	instance, ok := bot.instanceForSource(annotation.Source)
+
+	if !ok {
+		return ""
+	}
+
+	return bot.grafana[instance].Client.DashboardURL(annotation)
+}
+
 func tagInList(tag string, tagList []string) bool {
 	for _, t := range tagList {
 		if t == tag {
@@ -59,34 +88,101 @@ func allTagsExist(annotationsTags []string, subscribedTags []string) bool {
 }
 
 func (bot *Bot) listenAnnotations(ctx context.Context, annotationsChannel <-chan grafana.Annotation) error {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
 	for {
+		bot.heartbeat.Beat()
+
 		select {
 		case <-ctx.Done():
+			if pending := len(annotationsChannel); pending > 0 {
+				level.Warn(bot.logger).Log("msg", "drop pending annotations", "count", pending)
+			}
 			return nil
-		case annotation := <-annotationsChannel:
-			var tpl bytes.Buffer
-			bot.template.Execute(&tpl, &templateData{
-				Text:      annotation.Text,
-				Tags:      annotation.Tags,
-				Timestamp: annotation.Time,
-			})
-			renderedTpl := tpl.String()
+		case <-ticker.C:
+		case annotation, ok := <-annotationsChannel:
+			if !ok {
+				level.Info(bot.logger).Log("msg", "annotations channel is drained")
+				return nil
+			}
+
+			bot.sendAnnotation(ctx, annotation)
+		}
+	}
+}
 
-			chatAndTagsList, err := bot.store.List()
+// annotationData : template data of the annotation with alert rule details and dashboard link
+func (bot *Bot) annotationData(ctx context.Context, annotation grafana.Annotation) *templateData {
+	data := newTemplateData(annotation)
+	data.Alert = bot.alertRule(ctx, annotation)
+	data.URL = bot.annotationURL(annotation)
 
-			if err != nil {
-				level.Error(bot.logger).Log("msg", "failed to get list of chats", "err", err)
+	return data
+}
+
+func (bot *Bot) sendAnnotation(ctx context.Context, annotation grafana.Annotation) {
+	data := bot.annotationData(ctx, annotation)
+	// Messages by template name, every template is rendered once
+	messages := map[string]renderedMessage{}
+	render := func(name string) renderedMessage {
+		if message, ok := messages[name]; ok {
+			return message
+		}
+
+		message, err := bot.renderAnnotation(name, data)
+
+		if err != nil {
+			level.Error(bot.logger).Log("msg", "failed to execute template", "template", name, "annotation", annotation.ID, "err", err)
+			bot.reportTemplateError(name, err)
+			// Send the annotation as is rather than lose it
+			message = renderedMessage{text: fallbackMessage(data), options: app.MessageOptions{ParseMode: app.ParseModeHTML}}
+		}
+
+		messages[name] = message
+
+		return message
+	}
+
+	silenceKey, silenceable := bot.silenceKey(annotation, data.Alert)
+
+	chatAndTagsList, err := bot.store.List()
+	bot.recordStoreResult(err)
+
+	if err != nil {
+		level.Error(bot.logger).Log("msg", "failed to get list of chats", "err", err)
+	}
+
+	for _, chatAndTags := range chatAndTagsList {
+		if ctx.Err() != nil {
+			level.Warn(bot.logger).Log("msg", "annotation delivery aborted", "annotation", annotation.ID)
+			return
+		}
+
+		if filterFromStoreValue(chatAndTags).matches(annotation) {
+			var markup *telebot.ReplyMarkup
+
+			if silenceable {
+				markup = silenceMarkup(silenceKey)
 			}
 
-			for _, chatAndTags := range chatAndTagsList {
-				if allTagsExist(annotation.Tags, chatAndTags.Tags) {
-					bot.tb.Send(
-						chatAndTags.Chat,
-						renderedTpl,
-						&telebot.SendOptions{ParseMode: telebot.ModeHTML, ThreadID: chatAndTags.ThreadID},
-					)
-				}
+			result, err := bot.sendMessage(
+				chatAndTags.Chat,
+				render(chatAndTags.Template),
+				&telebot.SendOptions{ThreadID: chatAndTags.ThreadID, ReplyMarkup: markup},
+			)
+			bot.delivery.record(err)
+
+			if result.plainText {
+				bot.reportTemplateRejection(chatAndTags.Template, result.rejection)
 			}
+
+			if err != nil {
+				level.Error(bot.logger).Log("msg", "failed to send annotation", "chat", chatAndTags.Chat.ID, "err", err)
+				continue
+			}
+
+			bot.recordMatch(chatAndTags, time.Now())
 		}
 	}
 }
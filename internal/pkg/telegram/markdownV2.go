@@ -0,0 +1,231 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/telegramhtml"
+)
+
+// markdownV2Scanner : entities open at the scanned position of MarkdownV2 message,
+// see https://core.telegram.org/bots/api#markdownv2-style
+type markdownV2Scanner struct {
+	bold      bool
+	italic    bool
+	underline bool
+	strike    bool
+	spoiler   bool
+	code      bool
+	pre       bool
+	linkText  bool
+	linkURL   bool
+	url       strings.Builder
+}
+
+// closed : no entity is open, the message may be split here
+func (sc *markdownV2Scanner) closed() bool {
+	return !sc.bold && !sc.italic && !sc.underline && !sc.strike && !sc.spoiler &&
+		!sc.code && !sc.pre && !sc.linkText && !sc.linkURL
+}
+
+// next : scan the character or the entity marker at i, returns the next offset and the plain text of the scanned part
+func (sc *markdownV2Scanner) next(s string, i int) (int, string) {
+	c := s[i]
+
+	switch {
+	case c == '\\' && i+1 < len(s):
+		_, size := utf8.DecodeRuneInString(s[i+1:])
+
+		return sc.text(s[i+1:i+1+size], i+1+size)
+
+	case sc.pre:
+		if strings.HasPrefix(s[i:], "```") {
+			sc.pre = false
+			return i + 3, ""
+		}
+
+	case sc.code:
+		if c == '`' {
+			sc.code = false
+			return i + 1, ""
+		}
+
+	case sc.linkURL:
+		if c == ')' {
+			sc.linkURL = false
+			url := sc.url.String()
+			sc.url.Reset()
+
+			return i + 1, " (" + url + ")"
+		}
+
+	case strings.HasPrefix(s[i:], "```"):
+		sc.pre = true
+		i += 3
+
+		// Language of the code block
+		if end := strings.IndexByte(s[i:], '\n'); end >= 0 && !strings.ContainsAny(s[i:i+end], " `") {
+			i += end + 1
+		}
+
+		return i, ""
+
+	case c == '`':
+		sc.code = true
+		return i + 1, ""
+
+	case c == '*':
+		sc.bold = !sc.bold
+		return i + 1, ""
+
+	case strings.HasPrefix(s[i:], "__"):
+		sc.underline = !sc.underline
+		return i + 2, ""
+
+	case c == '_':
+		sc.italic = !sc.italic
+		return i + 1, ""
+
+	case c == '~':
+		sc.strike = !sc.strike
+		return i + 1, ""
+
+	case strings.HasPrefix(s[i:], "||"):
+		sc.spoiler = !sc.spoiler
+		return i + 2, ""
+
+	case strings.HasPrefix(s[i:], "!["):
+		// Custom emoji, its text is the emoji
+		sc.linkText = true
+		return i + 2, ""
+
+	case c == '[':
+		sc.linkText = true
+		return i + 1, ""
+
+	case c == ']' && sc.linkText:
+		sc.linkText = false
+
+		if strings.HasPrefix(s[i+1:], "(") {
+			sc.linkURL = true
+			return i + 2, ""
+		}
+
+		return i + 1, ""
+	}
+
+	_, size := utf8.DecodeRuneInString(s[i:])
+
+	return sc.text(s[i:i+size], i+size)
+}
+
+func (sc *markdownV2Scanner) text(text string, next int) (int, string) {
+	if sc.linkURL {
+		sc.url.WriteString(text)
+		return next, ""
+	}
+
+	return next, text
+}
+
+// markdownV2PlainText : text of MarkdownV2 message without entity markers and escaping, links are followed by URL
+func markdownV2PlainText(s string) string {
+	var (
+		sc    markdownV2Scanner
+		plain strings.Builder
+	)
+
+	for i := 0; i < len(s); {
+		var text string
+		i, text = sc.next(s, i)
+		plain.WriteString(text)
+	}
+
+	return plain.String()
+}
+
+// splitMarkdownV2 : split MarkdownV2 message into messages not longer than the limit outside entities and escapes,
+// at paragraphs, lines or words when possible
+//
+// The length is counted on the source, it is not shorter than the text Telegram counts. An entity longer than
+// the limit can't be split.
+func splitMarkdownV2(s string, limit int) ([]string, error) {
+	var (
+		sc       markdownV2Scanner
+		messages []string
+		start    int
+		size     int
+		// Safe split offsets of the current message with the message size before them
+		offsets []int
+		sizes   []int
+	)
+
+	for i := 0; i < len(s); {
+		next, _ := sc.next(s, i)
+
+		for _, r := range s[i:next] {
+			size += telegramhtml.UTF16RuneLen(r)
+		}
+
+		i = next
+
+		for size > limit {
+			if len(offsets) == 0 {
+				return nil, fmt.Errorf("can't split MarkdownV2 message, an entity after offset %d is longer than %d characters", start, limit)
+			}
+
+			split := len(offsets) - 1
+
+			for _, separator := range []string{"\n\n", "\n", " "} {
+				if j := lastOffsetAfter(s, offsets, separator); j >= 0 {
+					split = j
+					break
+				}
+			}
+
+			messages = appendMessage(messages, s[start:offsets[split]])
+			start = offsets[split]
+			size -= sizes[split]
+
+			// Offsets after the split are kept for the next message
+			base := sizes[split]
+			offsets = append([]int{}, offsets[split+1:]...)
+			sizes = append([]int{}, sizes[split+1:]...)
+
+			for j := range sizes {
+				sizes[j] -= base
+			}
+		}
+
+		if sc.closed() {
+			offsets = append(offsets, i)
+			sizes = append(sizes, size)
+		}
+	}
+
+	if sc.closed() {
+		return appendMessage(messages, s[start:]), nil
+	}
+
+	return nil, fmt.Errorf("can't split MarkdownV2 message, an entity after offset %d is not closed", start)
+}
+
+// lastOffsetAfter : index of the last offset following the separator
+func lastOffsetAfter(s string, offsets []int, separator string) int {
+	for j := len(offsets) - 1; j >= 0; j-- {
+		if strings.HasSuffix(s[:offsets[j]], separator) {
+			return j
+		}
+	}
+
+	return -1
+}
+
+func appendMessage(messages []string, message string) []string {
+	if strings.TrimSpace(message) == "" {
+		return messages
+	}
+
+	return append(messages, message)
+}
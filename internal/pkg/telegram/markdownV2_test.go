@@ -0,0 +1,122 @@
+package telegram
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMarkdownV2PlainText(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain text", "Deployed v1", "Deployed v1"},
+		{"escapes", `v1\.2\.3 \*not bold\* \\ \_`, `v1.2.3 *not bold* \ _`},
+		{"entities", "*bold* _italic_ __underline__ ~strike~ ||spoiler||", "bold italic underline strike spoiler"},
+		{"nested entities", "*bold _italic bold ~strike~_ bold*", "bold italic bold strike bold"},
+		{"link", "[Grafana](https://grafana/d/x?a=1)", "Grafana (https://grafana/d/x?a=1)"},
+		{"link with escapes", `[a \[b\]](https://a/\(x\))`, "a [b] (https://a/(x))"},
+		{"formatted link text", "[*bold* link](https://a)", "bold link (https://a)"},
+		{"brackets without link", `[text] rest`, "text rest"},
+		{"code", "`x *y* _z_`", "x *y* _z_"},
+		{"code with escapes", "`a \\` b`", "a ` b"},
+		{"pre", "```\nline *1*\n```", "line *1*\n"},
+		{"pre with language", "```go\nfmt.Println(\"x\")\n```", "fmt.Println(\"x\")\n"},
+		{"multibyte", "*привет* 🔴", "привет 🔴"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := markdownV2PlainText(test.in); got != test.want {
+				t.Errorf("markdownV2PlainText(%q) = %q, want %q", test.in, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSplitMarkdownV2(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		limit int
+		want  []string
+	}{
+		{"short", "*bold* text", 20, []string{"*bold* text"}},
+		{"paragraphs", "first paragraph\n\nsecond *bold* line", 20, []string{"first paragraph\n\n", "second *bold* line"}},
+		{"lines", "first line\nsecond line", 15, []string{"first line\n", "second line"}},
+		{"words", "one two three four", 10, []string{"one two ", "three four"}},
+		{"outside entity", "aa *b c d e f* g", 14, []string{"aa ", "*b c d e f* g"}},
+		{"outside link", "see [the dashboard](https://a) now", 30, []string{"see ", "[the dashboard](https://a) now"}},
+		{"outside code", "x `a b c d` y", 11, []string{"x ", "`a b c d` y"}},
+		{"escapes", `\.\.\.\.\.`, 4, []string{`\.\.`, `\.\.`, `\.`}},
+		{"UTF-16 length", "🔴🔴🔴", 4, []string{"🔴🔴", "🔴"}},
+		{"whitespace parts are dropped", "a\n\n\n\nb", 2, []string{"a\n", "\nb"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := splitMarkdownV2(test.in, test.limit)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("splitMarkdownV2(%q, %d) = %q, want %q", test.in, test.limit, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSplitMarkdownV2Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"oversized entity", "text *" + strings.Repeat("a", 30) + "*"},
+		{"oversized code", "`" + strings.Repeat("a ", 20) + "`"},
+		{"oversized link", "[link](https://a/" + strings.Repeat("a", 30) + ")"},
+		{"unclosed entity", "*open bold"},
+		{"unclosed pre", "```\ncode"},
+		{"unclosed link", "[link](https://a"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got, err := splitMarkdownV2(test.in, 20); err == nil {
+				t.Errorf("splitMarkdownV2(%q) = %q, want error", test.in, got)
+			}
+		})
+	}
+}
+
+func TestSplitMarkdownV2Long(t *testing.T) {
+	text := strings.Repeat("Deploy *v1\\.2* of [service](https://a/\\)) `code`\n", 200)
+	parts, err := splitMarkdownV2(text, 500)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if joined := strings.Join(parts, ""); joined != text {
+		t.Fatal("parts are not the whole message")
+	}
+
+	for i, part := range parts {
+		var sc markdownV2Scanner
+
+		for j := 0; j < len(part); {
+			j, _ = sc.next(part, j)
+		}
+
+		if !sc.closed() {
+			t.Errorf("part %d has unclosed entities: %q", i, part)
+		}
+
+		if len([]rune(part)) > 500 {
+			t.Errorf("part %d is longer than the limit: %d", i, len([]rune(part)))
+		}
+	}
+}
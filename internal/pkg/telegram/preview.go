@@ -0,0 +1,260 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	app "github.com/zt-sv/grafana-annotations-bot/internal/app/grafana-annotations-bot"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/database"
+	"gopkg.in/telebot.v3"
+)
+
+const (
+	previewCount = 3
+	// Template errors of annotations are reported to admins at most once per the interval
+	templateErrorReportInterval = time.Hour
+)
+
+// handlePreview : /preview [filter], render the last annotations matching the filter or the chat subscription
+// with the chat template
+func (bot *Bot) handlePreview(m *telebot.Message) error {
+	var (
+		subscription database.StoreValue
+		subscribed   bool
+	)
+
+	filter, err := bot.parseFilter(m.Payload)
+
+	if err == nil {
+		subscription, subscribed, err = bot.chatSubscription(m.Chat, m.ThreadID)
+	}
+
+	if err == nil && filter.isEmpty() {
+		filter = filterFromStoreValue(subscription)
+
+		if !subscribed {
+			err = fmt.Errorf("the chat is not subscribed, provide a filter")
+		}
+	}
+
+	if err != nil {
+		_, err := bot.tb.Send(
+			m.Chat,
+			fmt.Sprintf("%v\n\nExample:\n/preview\n/preview source=prod tags=deploy", err),
+			&telebot.SendOptions{ThreadID: m.ThreadID},
+		)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), grafanaRequestTimeout)
+	defer cancel()
+
+	annotations, unavailable := bot.findAnnotations(ctx, annotationSearch{filter: filter}, time.Time{}, time.Time{}, previewCount)
+	header := fmt.Sprintf("Preview of the last %d annotations matching:\n%s", len(annotations), filter)
+
+	if len(unavailable) > 0 {
+		header = fmt.Sprintf("%s\nUnavailable: %s", header, strings.Join(unavailable, ", "))
+	}
+
+	if len(annotations) == 0 {
+		header = fmt.Sprintf("No annotations matching:\n%s", filter)
+	}
+
+	if _, err := bot.tb.Send(m.Chat, header, &telebot.SendOptions{ThreadID: m.ThreadID}); err != nil {
+		return err
+	}
+
+	// Grafana returns the newest annotations first, send them in the chat order
+	for i := len(annotations) - 1; i >= 0; i-- {
+		if err := bot.sendPreview(m, subscription.Template, bot.annotationData(ctx, annotations[i])); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// handleTestTemplate : /testtemplate [name], render a sample annotation with every field set
+// with the template, the chat template by default
+func (bot *Bot) handleTestTemplate(m *telebot.Message) error {
+	name := strings.TrimSpace(m.Payload)
+
+	if name == "" {
+		subscription, _, err := bot.chatSubscription(m.Chat, m.ThreadID)
+
+		if err != nil {
+			_, err := bot.tb.Send(m.Chat, err.Error(), &telebot.SendOptions{ThreadID: m.ThreadID})
+			return err
+		}
+
+		name = subscription.Template
+	}
+
+	if _, ok := bot.templates.get(name); !ok {
+		_, err := bot.tb.Send(m.Chat, fmt.Sprintf("Unknown template %s, see /template list", name), &telebot.SendOptions{ThreadID: m.ThreadID})
+		return err
+	}
+
+	return bot.sendPreview(m, name, bot.sampleTemplateData(time.Now()))
+}
+
+// chatSubscription : subscription of the chat, zero value when the chat is not subscribed
+func (bot *Bot) chatSubscription(chat *telebot.Chat, threadID int) (database.StoreValue, bool, error) {
+	exist, err := bot.store.ExistChat(chat, threadID)
+
+	if err == nil && exist {
+		subscription, err := bot.store.GetChat(chat, threadID)
+
+		if err == nil {
+			return subscription, true, nil
+		}
+	}
+
+	if err != nil {
+		return database.StoreValue{}, false, fmt.Errorf("failed to get the chat subscription: %w", err)
+	}
+
+	return database.StoreValue{}, false, nil
+}
+
+// sendPreview : render the message with the named template and send it, template and Telegram errors are sent instead
+func (bot *Bot) sendPreview(m *telebot.Message, name string, data *templateData) error {
+	message, err := bot.renderAnnotation(name, data)
+
+	if err != nil {
+		_, err := bot.tb.Send(
+			m.Chat,
+			"⚠️ Template error:\n"+err.Error(),
+			&telebot.SendOptions{ThreadID: m.ThreadID},
+		)
+		return err
+	}
+
+	result, err := bot.sendMessage(m.Chat, message, &telebot.SendOptions{ThreadID: m.ThreadID})
+	rejection := result.rejection
+
+	if err != nil {
+		rejection = err.Error()
+	}
+
+	if rejection == "" {
+		return nil
+	}
+
+	level.Warn(bot.logger).Log("msg", "telegram rejected rendered template", "err", rejection)
+	_, err = bot.tb.Send(
+		m.Chat,
+		"⚠️ Telegram rejected the rendered message:\n"+rejection,
+		&telebot.SendOptions{ThreadID: m.ThreadID},
+	)
+
+	return err
+}
+
+// sampleTemplateData : template data with every field set
+func (bot *Bot) sampleTemplateData(now time.Time) *templateData {
+	data := &templateData{
+		Source:    "default",
+		OrgID:     1,
+		Text:      "High CPU usage\nCPU usage of web-1 is above 90% for 5 minutes",
+		Tags:      []string{"alert", "prod", "web"},
+		Timestamp: now.UnixNano() / int64(time.Millisecond),
+		State:     stateAlerting,
+		PrevState: stateNormal,
+		Alert: &alertData{
+			UID:   "high-cpu",
+			Title: "High CPU usage",
+			Group: "nodes",
+			Labels: map[string]string{
+				"alertname": "HighCPU",
+				"instance":  "web-1",
+				"severity":  "critical",
+			},
+			Annotations: map[string]string{
+				"summary":     "High CPU usage",
+				"description": "CPU usage of web-1 is above 90% for 5 minutes",
+				"runbook_url": "https://example.com/runbooks/high-cpu",
+			},
+			Summary:     "High CPU usage",
+			Description: "CPU usage of web-1 is above 90% for 5 minutes",
+			RunbookURL:  "https://example.com/runbooks/high-cpu",
+			Severity:    "critical",
+			Datasources: []string{"Prometheus"},
+		},
+		URL: "https://grafana.example.com/d/nodes?orgId=1&viewPanel=2",
+	}
+
+	if len(bot.grafana) > 0 {
+		data.Source = bot.grafana[0].Name
+		data.URL = bot.grafana[0].Client.DashboardRangeURL("nodes", 1, now.Add(-time.Hour), now.Add(time.Hour))
+	}
+
+	return data
+}
+
+// fallbackMessage : plain annotation message for template errors
+func fallbackMessage(data *templateData) string {
+	return fmt.Sprintf(
+		"<b>%s</b>\n%s\n\nWith tags: %s\nHappened: %s",
+		html.EscapeString(data.Title()),
+		html.EscapeString(data.Message()),
+		html.EscapeString(strings.Join(data.Tags, ", ")),
+		data.FormattedDate(),
+	)
+}
+
+// reportTemplateError : notify admins that the template failed to render, at most once per
+// templateErrorReportInterval for each template
+func (bot *Bot) reportTemplateError(name string, err error) {
+	if name == "" {
+		name = app.DefaultTemplateName
+	}
+
+	if !bot.templateReportDue("error/" + name) {
+		return
+	}
+
+	bot.notifyPipelineAdmins(fmt.Sprintf(
+		"⚠️ <b>Message template %s failed</b>\nAnnotations are sent without the template, check it with /testtemplate %s\n%s",
+		html.EscapeString(name),
+		html.EscapeString(name),
+		html.EscapeString(err.Error()),
+	))
+}
+
+// reportTemplateRejection : notify admins that Telegram rejected the formatting of the rendered template, at most
+// once per templateErrorReportInterval for each template
+func (bot *Bot) reportTemplateRejection(name string, rejection string) {
+	if name == "" {
+		name = app.DefaultTemplateName
+	}
+
+	if !bot.templateReportDue("rejection/" + name) {
+		return
+	}
+
+	bot.notifyPipelineAdmins(fmt.Sprintf(
+		"⚠️ <b>Message template %s is sent as plain text</b>\nThe template renders, but Telegram rejected the formatting, check it with /testtemplate %s\n%s",
+		html.EscapeString(name),
+		html.EscapeString(name),
+		html.EscapeString(rejection),
+	))
+}
+
+// templateReportDue : whether the report with the key was not sent for templateErrorReportInterval, records it as sent
+func (bot *Bot) templateReportDue(key string) bool {
+	bot.templateErrorsMu.Lock()
+	defer bot.templateErrorsMu.Unlock()
+
+	if time.Since(bot.templateErrorsReported[key]) < templateErrorReportInterval {
+		return false
+	}
+
+	bot.templateErrorsReported[key] = time.Now()
+
+	return true
+}
@@ -0,0 +1,75 @@
+package telegram
+
+import (
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/telebot.v3"
+)
+
+const reportPeriod = 7 * 24 * time.Hour
+
+// ReportOptions : scheduled weekly statistics reports config
+type ReportOptions struct {
+	// Chats : chats to send reports to, no reports when empty
+	Chats []int64
+	// Weekday : day of the week to send reports on
+	Weekday time.Weekday
+	// At : local time of the day to send reports at, since midnight
+	At time.Duration
+	// GroupBy : statistics grouping, one of app.GroupByTag, app.GroupByDashboard and app.GroupByUser
+	GroupBy string
+}
+
+// nextReportTime : the nearest report time after now
+func nextReportTime(now time.Time, weekday time.Weekday, at time.Duration) time.Time {
+	days := (int(weekday) - int(now.Weekday()) + 7) % 7
+	hour, minute := int(at/time.Hour), int(at%time.Hour/time.Minute)
+	next := time.Date(now.Year(), now.Month(), now.Day()+days, hour, minute, 0, 0, now.Location())
+
+	if !next.After(now) {
+		next = time.Date(now.Year(), now.Month(), now.Day()+days+7, hour, minute, 0, 0, now.Location())
+	}
+
+	return next
+}
+
+func (bot *Bot) sendReports(stop <-chan struct{}) error {
+	if len(bot.reports.Chats) == 0 {
+		<-stop
+		return nil
+	}
+
+	for {
+		next := nextReportTime(time.Now(), bot.reports.Weekday, bot.reports.At)
+		level.Info(bot.logger).Log("msg", "schedule statistics report", "at", next)
+		timer := time.NewTimer(time.Until(next))
+
+		select {
+		case <-stop:
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+
+		text, err := bot.renderStats("Weekly report", annotationFilter{}, reportPeriod, bot.reports.GroupBy)
+
+		if err != nil {
+			level.Error(bot.logger).Log("msg", "failed to render statistics report", "err", err)
+			continue
+		}
+
+		for _, chatID := range bot.reports.Chats {
+			_, err := bot.sendHTML(
+				telebot.ChatID(chatID),
+				text,
+				&telebot.SendOptions{DisableWebPagePreview: true},
+			)
+			bot.delivery.record(err)
+
+			if err != nil {
+				level.Error(bot.logger).Log("msg", "failed to send statistics report", "chat", chatID, "err", err)
+			}
+		}
+	}
+}
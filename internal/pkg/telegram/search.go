@@ -0,0 +1,117 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/telebot.v3"
+)
+
+const (
+	defaultSearchPeriod = 7 * 24 * time.Hour
+	maxSearchResults    = 100
+	// Telegram shows up to 50 inline query results
+	maxInlineResults   = 50
+	inlineQueryTimeout = 10 * time.Second
+	inlineCacheTime    = 60
+)
+
+// parseSearch : "<text> [since]", since is the last word, which parses as time
+func parseSearch(payload string, now time.Time) (string, time.Time, error) {
+	fields := strings.Fields(payload)
+	since := now.Add(-defaultSearchPeriod)
+
+	if len(fields) > 1 {
+		if t, err := parseTimeArg(fields[len(fields)-1], now); err == nil {
+			since = t
+			fields = fields[:len(fields)-1]
+		}
+	}
+
+	if len(fields) == 0 {
+		return "", since, errors.New("search text is required")
+	}
+
+	return strings.Join(fields, " "), since, nil
+}
+
+// handleSearch : /search <text> [since]
+func (bot *Bot) handleSearch(m *telebot.Message) error {
+	text, since, err := parseSearch(m.Payload, time.Now())
+
+	if err != nil {
+		_, err := bot.tb.Send(
+			m.Chat,
+			fmt.Sprintf("Invalid request: %v\n\nExample:\n/search v1.2.3\n/search INC-1234 30d", err),
+			&telebot.SendOptions{ThreadID: m.ThreadID},
+		)
+		return err
+	}
+
+	title := fmt.Sprintf("Annotations with %q since %s", text, since.Format(time.RFC1123))
+
+	return bot.sendHistory(m, title, annotationSearch{text: text, since: since}, since, time.Now(), maxSearchResults)
+}
+
+// handleInlineQuery : search annotations by "@bot <text> [since]" in any chat, the chosen one is posted with the message template
+func (bot *Bot) handleInlineQuery(c telebot.Context) error {
+	response := &telebot.QueryResponse{
+		Results:    telebot.Results{},
+		CacheTime:  inlineCacheTime,
+		IsPersonal: true,
+	}
+
+	if !bot.isAdminID(c.Sender().ID) {
+		level.Error(bot.logger).Log("msg", "Receive inline query from not admin user")
+		return c.Answer(response)
+	}
+
+	text, since, err := parseSearch(c.Query().Text, time.Now())
+
+	if err != nil {
+		return c.Answer(response)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), inlineQueryTimeout)
+	defer cancel()
+
+	annotations, _ := bot.findAnnotations(ctx, annotationSearch{text: text, since: since}, since, time.Now(), maxInlineResults)
+
+	for i, annotation := range annotations {
+		data := newTemplateData(annotation)
+		data.URL = bot.annotationURL(annotation)
+
+		message, err := bot.renderAnnotation("", data)
+
+		if err != nil {
+			level.Error(bot.logger).Log("msg", "failed to render annotation", "annotation", annotation.ID, "err", err)
+			continue
+		}
+
+		result := &telebot.ArticleResult{
+			Title:       data.Title(),
+			Description: fmt.Sprintf("%s %s", data.FormattedDate(), strings.Join(data.Tags, ", ")),
+			URL:         data.URL,
+			HideURL:     true,
+		}
+		result.SetResultID(strconv.Itoa(i))
+		result.SetContent(&telebot.InputTextMessageContent{
+			Text:           message.inlineText(),
+			ParseMode:      message.parseMode(),
+			DisablePreview: true,
+		})
+
+		if markup := message.markup(nil); markup != nil {
+			result.SetReplyMarkup(markup)
+		}
+
+		response.Results = append(response.Results, result)
+	}
+
+	return c.Answer(response)
+}
@@ -0,0 +1,116 @@
+package telegram
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/telegramhtml"
+	"gopkg.in/telebot.v3"
+)
+
+var errEmptyMessage = errors.New("message is empty")
+
+// parseEntitiesError : description of Telegram error on bad formatting, Telegram has no error code for it
+const parseEntitiesError = "can't parse entities"
+
+// sendResult : how the message was sent
+type sendResult struct {
+	// plainText : Telegram rejected the formatting and some parts were sent as plain text
+	plainText bool
+	// rejection : Telegram description of the rejected formatting
+	rejection string
+}
+
+// isParseError : Telegram failed to parse formatting entities of the message
+//
+// telebot returns unknown Telegram errors as plain errors with the description, not as *telebot.Error.
+func isParseError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), parseEntitiesError)
+}
+
+// sendMessage : send the rendered annotation message with the parse mode, options and keyboard of the template
+func (bot *Bot) sendMessage(to telebot.Recipient, message renderedMessage, options *telebot.SendOptions) (sendResult, error) {
+	messageOptions := *options
+	messageOptions.DisableWebPagePreview = options.DisableWebPagePreview || message.options.DisableWebPagePreview
+	messageOptions.Protected = options.Protected || message.options.ProtectContent
+	messageOptions.ReplyMarkup = message.markup(options.ReplyMarkup)
+
+	switch message.parseMode() {
+	case telebot.ModeMarkdownV2:
+		return bot.sendMarkdownV2(to, message.text, &messageOptions)
+	case telebot.ModeDefault:
+		parts := telegramhtml.SplitText(message.text, telegramhtml.MaxMessageLength)
+		return bot.sendParts(to, parts, telebot.ModeDefault, nil, &messageOptions)
+	default:
+		return bot.sendHTML(to, message.text, &messageOptions)
+	}
+}
+
+// sendMarkdownV2 : split MarkdownV2 message outside entities and send it
+//
+// A message which can't be split is sent as plain text, the reason is reported as the rejection.
+func (bot *Bot) sendMarkdownV2(to telebot.Recipient, text string, options *telebot.SendOptions) (sendResult, error) {
+	parts, splitErr := splitMarkdownV2(text, telegramhtml.MaxMessageLength)
+
+	if splitErr == nil {
+		return bot.sendParts(to, parts, telebot.ModeMarkdownV2, markdownV2PlainText, options)
+	}
+
+	level.Warn(bot.logger).Log("msg", "sending MarkdownV2 message as plain text", "err", splitErr)
+	parts = telegramhtml.SplitText(markdownV2PlainText(text), telegramhtml.MaxMessageLength)
+	_, err := bot.sendParts(to, parts, telebot.ModeDefault, nil, options)
+
+	return sendResult{plainText: true, rejection: splitErr.Error()}, err
+}
+
+// sendHTML : sanitize the rendered message to Telegram HTML, split it to the length limit and send it
+//
+// Reply markup is attached to the last message. When Telegram still rejects the formatting, the message is sent
+// as plain text, see sendResult.
+func (bot *Bot) sendHTML(to telebot.Recipient, text string, options *telebot.SendOptions) (sendResult, error) {
+	parts := telegramhtml.Split(text, telegramhtml.MaxMessageLength)
+
+	return bot.sendParts(to, parts, telebot.ModeHTML, telegramhtml.PlainText, options)
+}
+
+// sendParts : send parts of the split message, plainText converts a part rejected by Telegram to plain text
+func (bot *Bot) sendParts(
+	to telebot.Recipient,
+	parts []string,
+	mode telebot.ParseMode,
+	plainText func(string) string,
+	options *telebot.SendOptions,
+) (sendResult, error) {
+	var result sendResult
+
+	if len(parts) == 0 {
+		return result, errEmptyMessage
+	}
+
+	for i, part := range parts {
+		partOptions := *options
+		partOptions.ParseMode = mode
+
+		if i < len(parts)-1 {
+			partOptions.ReplyMarkup = nil
+		}
+
+		_, err := bot.tb.Send(to, part, &partOptions)
+
+		if plainText != nil && isParseError(err) {
+			result.plainText = true
+			result.rejection = err.Error()
+			level.Warn(bot.logger).Log("msg", "telegram rejected message formatting, sending plain text", "err", err)
+
+			partOptions.ParseMode = telebot.ModeDefault
+			_, err = bot.tb.Send(to, plainText(part), &partOptions)
+		}
+
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
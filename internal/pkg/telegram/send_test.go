@@ -0,0 +1,164 @@
+package telegram
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	app "github.com/zt-sv/grafana-annotations-bot/internal/app/grafana-annotations-bot"
+	"gopkg.in/telebot.v3"
+)
+
+// sentMessage : sendMessage request received by the fake Bot API
+type sentMessage struct {
+	Text      string `json:"text"`
+	ParseMode string `json:"parse_mode"`
+}
+
+// newFakeBotAPI : bot talking to Bot API, which rejects formatted messages containing reject
+// with the parse error like Telegram does
+func newFakeBotAPI(t *testing.T, reject string) (*Bot, func() []sentMessage) {
+	t.Helper()
+
+	var (
+		mu   sync.Mutex
+		sent []sentMessage
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message sentMessage
+
+		if !strings.HasSuffix(r.URL.Path, "/sendMessage") || json.NewDecoder(r.Body).Decode(&message) != nil {
+			http.Error(w, `{"ok": false, "error_code": 404, "description": "Not Found"}`, http.StatusNotFound)
+			return
+		}
+
+		mu.Lock()
+		sent = append(sent, message)
+		mu.Unlock()
+
+		if message.ParseMode != "" && strings.Contains(message.Text, reject) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"ok": false, "error_code": 400, "description": "Bad Request: can't parse entities: unsupported start tag \"x\" at byte offset 0"}`))
+			return
+		}
+
+		w.Write([]byte(`{"ok": true, "result": {"message_id": 1, "chat": {"id": 1}, "text": "sent"}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	tb, err := telebot.NewBot(telebot.Settings{URL: server.URL, Token: "token", Offline: true})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bot := &Bot{tb: tb, logger: log.NewNopLogger()}
+
+	return bot, func() []sentMessage {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return append([]sentMessage{}, sent...)
+	}
+}
+
+func TestIsParseError(t *testing.T) {
+	bot, _ := newFakeBotAPI(t, "<x>")
+
+	_, err := bot.tb.Send(&telebot.Chat{ID: 1}, "<x>", &telebot.SendOptions{ParseMode: telebot.ModeHTML})
+
+	if !isParseError(err) {
+		t.Errorf("isParseError(%v) = false, want true", err)
+	}
+
+	if isParseError(nil) {
+		t.Error("isParseError(nil) = true")
+	}
+}
+
+func TestSendMessagePlainTextRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		parseMode string
+		text      string
+		want      []sentMessage
+	}{
+		{
+			name:      "HTML",
+			parseMode: app.ParseModeHTML,
+			text:      "<b>bad</b> &amp; [x]",
+			want: []sentMessage{
+				{Text: "<b>bad</b> &amp; [x]", ParseMode: "HTML"},
+				{Text: "bad & [x]"},
+			},
+		},
+		{
+			name:      "MarkdownV2",
+			parseMode: app.ParseModeMarkdownV2,
+			text:      `*bad* \[x\]`,
+			want: []sentMessage{
+				{Text: `*bad* \[x\]`, ParseMode: "MarkdownV2"},
+				{Text: "bad [x]"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bot, sent := newFakeBotAPI(t, "[x")
+			message := renderedMessage{text: test.text, options: app.MessageOptions{ParseMode: test.parseMode}}
+
+			result, err := bot.sendMessage(&telebot.Chat{ID: 1}, message, &telebot.SendOptions{})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !result.plainText || !strings.Contains(result.rejection, parseEntitiesError) {
+				t.Errorf("result %+v, want plain text with the rejection", result)
+			}
+
+			if got := sent(); !equalSentMessages(got, test.want) {
+				t.Errorf("sent %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSendMessageFormatted(t *testing.T) {
+	bot, sent := newFakeBotAPI(t, "<x>")
+	message := renderedMessage{text: "<b>ok</b>", options: app.MessageOptions{ParseMode: app.ParseModeHTML}}
+
+	result, err := bot.sendMessage(&telebot.Chat{ID: 1}, message, &telebot.SendOptions{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.plainText {
+		t.Errorf("result %+v, want formatted", result)
+	}
+
+	if got, want := sent(), []sentMessage{{Text: "<b>ok</b>", ParseMode: "HTML"}}; !equalSentMessages(got, want) {
+		t.Errorf("sent %+v, want %+v", got, want)
+	}
+}
+
+func equalSentMessages(a []sentMessage, b []sentMessage) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
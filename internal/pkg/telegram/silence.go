@@ -0,0 +1,405 @@
+package telegram
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/grafana"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/source"
+	"gopkg.in/telebot.v3"
+)
+
+const (
+	buttonSilence   = "silence"
+	buttonUnsilence = "unsilence"
+
+	// Silence buttons of older annotations stop working
+	silenceTargetTTL = 24 * time.Hour
+	// alertRuleUIDLabel : Grafana label of the alert rule UID, it silences all the alerts of the rule
+	alertRuleUIDLabel = "__alert_rule_uid__"
+)
+
+var silenceButtonDurations = []string{"1h", "4h", "24h"}
+
+// silenceTarget : what silence buttons of an annotation silence
+type silenceTarget struct {
+	instance int
+	orgID    int64
+	matchers []grafana.Matcher
+}
+
+// key : the same alert gets the same key
+func (t silenceTarget) key() string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d/%d", t.instance, t.orgID)
+
+	for _, matcher := range t.matchers {
+		fmt.Fprintf(hash, "/%s", matcher)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// instanceForSource : index of the Grafana instance the annotation came from
+//
+// Alerts received by webhook belong to the only configured instance.
+func (bot *Bot) instanceForSource(name string) (int, bool) {
+	for i, instance := range bot.grafana {
+		if instance.Name == name {
+			return i, true
+		}
+	}
+
+	if name == source.WebhookSourceName && len(bot.grafana) == 1 {
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// instanceByName : index of the Grafana instance, empty name means the only configured instance
+func (bot *Bot) instanceByName(name string) (int, error) {
+	if name == "" {
+		if len(bot.grafana) != 1 {
+			return 0, errors.New("several Grafana instances are configured, specify one with @<instance>")
+		}
+
+		return 0, nil
+	}
+
+	for i, instance := range bot.grafana {
+		if instance.Name == name {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown Grafana instance %q", name)
+}
+
+// silenceKey : register silence target of firing alert annotation, false for other annotations
+//
+// Alerts received by webhook are silenced by their labels. Annotations polled from Grafana have no labels, they
+// silence the alert rule.
+func (bot *Bot) silenceKey(annotation grafana.Annotation, rule *alertData) (string, bool) {
+	if !isAlertingState(annotation.NewState) {
+		return "", false
+	}
+
+	matchers := grafana.MatchersFromLabels(annotation.Labels)
+
+	if len(matchers) == 0 {
+		ruleUID := annotation.AlertUID
+
+		if rule != nil && rule.UID != "" {
+			ruleUID = rule.UID
+		}
+
+		if ruleUID == "" {
+			return "", false
+		}
+
+		matchers = []grafana.Matcher{{Name: alertRuleUIDLabel, Value: ruleUID, IsEqual: true}}
+	}
+
+	instance, ok := bot.instanceForSource(annotation.Source)
+
+	if !ok {
+		return "", false
+	}
+
+	target := silenceTarget{
+		instance: instance,
+		orgID:    annotation.OrgID,
+		matchers: matchers,
+	}
+	key := target.key()
+	bot.silences.put(key, target)
+
+	return key, true
+}
+
+// silenceMarkup : silence buttons, telebot modifies markup on send, so it is created for every message
+func silenceMarkup(key string) *telebot.ReplyMarkup {
+	markup := &telebot.ReplyMarkup{}
+	buttons := make([]telebot.Btn, 0, len(silenceButtonDurations))
+
+	for _, duration := range silenceButtonDurations {
+		buttons = append(buttons, markup.Data("Silence "+duration, buttonSilence, key, duration))
+	}
+
+	markup.Inline(markup.Row(buttons...))
+
+	return markup
+}
+
+func (bot *Bot) handleSilenceButton(c telebot.Context) error {
+	args := c.Args()
+
+	if len(args) != 2 {
+		return c.Respond(&telebot.CallbackResponse{Text: "Bad silence button"})
+	}
+
+	target, ok := bot.silences.get(args[0])
+
+	if !ok {
+		return c.Respond(&telebot.CallbackResponse{Text: "Silence button expired, use /silence", ShowAlert: true})
+	}
+
+	duration, err := parseDuration(args[1])
+
+	if err != nil {
+		return c.Respond(&telebot.CallbackResponse{Text: "Bad silence duration"})
+	}
+
+	silenceID, endsAt, err := bot.createSilence(target.instance, target.orgID, target.matchers, duration, "", c.Sender())
+
+	if err != nil {
+		return c.Respond(&telebot.CallbackResponse{Text: "Failed to create silence: " + grafana.Describe(err), ShowAlert: true})
+	}
+
+	if err := c.Respond(&telebot.CallbackResponse{Text: "Silence created"}); err != nil {
+		level.Warn(bot.logger).Log("msg", "failed to answer callback", "err", err)
+	}
+
+	return bot.sendSilenceCreated(c.Chat(), c.Message().ThreadID, target.instance, target.orgID, silenceID, target.matchers, endsAt)
+}
+
+func (bot *Bot) handleUnsilenceButton(c telebot.Context) error {
+	args := c.Args()
+
+	if len(args) != 3 {
+		return c.Respond(&telebot.CallbackResponse{Text: "Bad expire button"})
+	}
+
+	instance, err := strconv.Atoi(args[0])
+
+	if err != nil || instance < 0 || instance >= len(bot.grafana) {
+		return c.Respond(&telebot.CallbackResponse{Text: "Unknown Grafana instance"})
+	}
+
+	orgID, err := strconv.ParseInt(args[1], 10, 64)
+
+	if err != nil {
+		return c.Respond(&telebot.CallbackResponse{Text: "Bad expire button"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), grafanaRequestTimeout)
+	defer cancel()
+
+	silenceID := args[2]
+
+	if err := bot.grafana[instance].Client.WithOrg(orgID).ExpireSilence(ctx, silenceID); err != nil {
+		return c.Respond(&telebot.CallbackResponse{Text: "Failed to expire silence: " + grafana.Describe(err), ShowAlert: true})
+	}
+
+	level.Info(bot.logger).Log("msg", "silence expired", "silence", silenceID, "user", c.Sender().ID)
+
+	if err := c.Respond(&telebot.CallbackResponse{Text: "Silence expired"}); err != nil {
+		level.Warn(bot.logger).Log("msg", "failed to answer callback", "err", err)
+	}
+
+	return c.Edit(fmt.Sprintf("Silence %s expired by %s", silenceID, userName(c.Sender())))
+}
+
+// silenceCommand : parsed /silence command
+type silenceCommand struct {
+	instance int
+	orgID    int64
+	matchers []grafana.Matcher
+	duration time.Duration
+	comment  string
+}
+
+// handleSilence : /silence [@instance[:org]] <matchers> <duration> [comment]
+func (bot *Bot) handleSilence(m *telebot.Message) error {
+	cmd, err := bot.parseSilenceCommand(m.Payload)
+
+	if err != nil {
+		_, err := bot.tb.Send(
+			m.Chat,
+			fmt.Sprintf("Invalid silence: %v\n\nExample:\n/silence alertname=HighCPU,instance=~\"web-.*\" 2h maintenance", err),
+			&telebot.SendOptions{ThreadID: m.ThreadID},
+		)
+		return err
+	}
+
+	silenceID, endsAt, err := bot.createSilence(cmd.instance, cmd.orgID, cmd.matchers, cmd.duration, cmd.comment, m.Sender)
+
+	if err != nil {
+		_, err := bot.tb.Send(
+			m.Chat,
+			fmt.Sprintf("Failed to create silence\n%s\nError: %v", grafana.Describe(err), err),
+			&telebot.SendOptions{ThreadID: m.ThreadID},
+		)
+		return err
+	}
+
+	return bot.sendSilenceCreated(m.Chat, m.ThreadID, cmd.instance, cmd.orgID, silenceID, cmd.matchers, endsAt)
+}
+
+func (bot *Bot) parseSilenceCommand(payload string) (silenceCommand, error) {
+	var (
+		cmd          silenceCommand
+		err          error
+		fields       = splitUnquoted(payload, unicode.IsSpace)
+		instanceName = ""
+	)
+
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+		instanceName = strings.TrimPrefix(fields[0], "@")
+		fields = fields[1:]
+
+		if i := strings.LastIndex(instanceName, ":"); i >= 0 {
+			cmd.orgID, err = strconv.ParseInt(instanceName[i+1:], 10, 64)
+
+			if err != nil {
+				return cmd, fmt.Errorf("bad organization ID %q", instanceName[i+1:])
+			}
+
+			instanceName = instanceName[:i]
+		}
+	}
+
+	cmd.instance, err = bot.instanceByName(instanceName)
+
+	if err != nil {
+		return cmd, err
+	}
+
+	for i, field := range fields {
+		if duration, err := parseDuration(field); err == nil {
+			cmd.duration = duration
+			cmd.comment = strings.Join(fields[i+1:], " ")
+			break
+		}
+
+		for _, s := range splitUnquoted(field, func(r rune) bool { return r == ',' }) {
+			if s == "" {
+				continue
+			}
+
+			matcher, err := grafana.ParseMatcher(s)
+
+			if err != nil {
+				return cmd, err
+			}
+
+			cmd.matchers = append(cmd.matchers, matcher)
+		}
+	}
+
+	if len(cmd.matchers) == 0 {
+		return cmd, errors.New("no matchers")
+	}
+
+	if cmd.duration == 0 {
+		return cmd, errors.New("no duration")
+	}
+
+	return cmd, nil
+}
+
+func (bot *Bot) createSilence(instance int, orgID int64, matchers []grafana.Matcher, duration time.Duration, comment string, user *telebot.User) (string, time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), grafanaRequestTimeout)
+	defer cancel()
+
+	createdBy := userName(user)
+
+	if comment == "" {
+		comment = "Silenced from Telegram by " + createdBy
+	}
+
+	now := time.Now()
+	silence := grafana.Silence{
+		Matchers:  matchers,
+		StartsAt:  now,
+		EndsAt:    now.Add(duration),
+		CreatedBy: createdBy,
+		Comment:   comment,
+	}
+
+	silenceID, err := bot.grafana[instance].Client.WithOrg(orgID).CreateSilence(ctx, silence)
+
+	if err != nil {
+		level.Error(bot.logger).Log("msg", "failed to create silence", "grafana", bot.grafana[instance].Name, "err", err)
+		return "", time.Time{}, err
+	}
+
+	level.Info(bot.logger).Log("msg", "silence created", "grafana", bot.grafana[instance].Name, "silence", silenceID, "user", user.ID)
+
+	return silenceID, silence.EndsAt, nil
+}
+
+func (bot *Bot) sendSilenceCreated(chat *telebot.Chat, threadID int, instance int, orgID int64, silenceID string, matchers []grafana.Matcher, endsAt time.Time) error {
+	formatted := make([]string, 0, len(matchers))
+
+	for _, matcher := range matchers {
+		formatted = append(formatted, matcher.String())
+	}
+
+	markup := &telebot.ReplyMarkup{}
+	markup.Inline(markup.Row(
+		markup.Data("Expire", buttonUnsilence, strconv.Itoa(instance), strconv.FormatInt(orgID, 10), silenceID),
+	))
+
+	_, err := bot.tb.Send(
+		chat,
+		fmt.Sprintf("Silence %s created until %s\n%s", silenceID, endsAt.Format(time.RFC1123), strings.Join(formatted, "\n")),
+		&telebot.SendOptions{ThreadID: threadID, ReplyMarkup: markup},
+	)
+
+	return err
+}
+
+func userName(user *telebot.User) string {
+	if user.Username != "" {
+		return "@" + user.Username
+	}
+
+	return strings.TrimSpace(user.FirstName + " " + user.LastName)
+}
+
+// splitUnquoted : split the string at separators outside double quoted values, e.g. regex matchers with commas,
+// empty parts are skipped
+func splitUnquoted(s string, isSeparator func(rune) bool) []string {
+	var (
+		parts   []string
+		part    strings.Builder
+		quoted  bool
+		escaped bool
+	)
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case !quoted && isSeparator(r):
+			if part.Len() > 0 {
+				parts = append(parts, part.String())
+				part.Reset()
+			}
+
+			continue
+		}
+
+		part.WriteRune(r)
+	}
+
+	if part.Len() > 0 {
+		parts = append(parts, part.String())
+	}
+
+	return parts
+}
@@ -3,57 +3,91 @@ package telegram
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/go-kit/kit/log/level"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/database"
 	"gopkg.in/telebot.v3"
 )
 
 func (bot *Bot) handleStart(m *telebot.Message) error {
-	if m.Payload == "" {
+	filter, err := bot.parseFilter(m.Payload)
+
+	if err != nil {
+		level.Warn(bot.logger).Log("msg", "Chat provide invalid filter", "chat", m.Chat.ID, "err", err)
+		_, err := bot.tb.Send(
+			m.Chat,
+			fmt.Sprintf("Invalid filter: %v\n\nExample:\n/start org=3 tags=tagName,anotherOneTag", err),
+			&telebot.SendOptions{ThreadID: m.ThreadID},
+		)
+		return err
+	}
+
+	if filter.isEmpty() {
 		level.Warn(bot.logger).Log("msg", "Chat not provide tags for subscribe", "chat", m.Chat.ID)
 		_, err := bot.tb.Send(
 			m.Chat,
-			"*You're not provide any tag*\nPlease, provide tags.\n\n*Example:*\n/start tagName,anotherOneTag",
+			"*You're not provide any tag*\nPlease, provide tags.\n\n*Example:*\n/start tagName,anotherOneTag\n/start org=3 tags=tagName,anotherOneTag",
 			&telebot.SendOptions{ParseMode: telebot.ModeMarkdown, ThreadID: m.ThreadID},
 		)
 		return err
 	}
 
-	exist, err := bot.store.ExistChat(m.Chat, m.ThreadID)
+	reply, err := bot.subscribe(m.Chat, m.ThreadID, filter)
+
+	if reply == "" {
+		return err
+	}
+
+	_, sendErr := bot.tb.Send(m.Chat, reply, &telebot.SendOptions{ThreadID: m.ThreadID})
 
 	if err != nil {
-		level.Error(bot.logger).Log("msg", "Could check key in store", "err", err)
 		return err
 	}
 
+	return sendErr
+}
+
+// subscribe : save the chat subscription, returns reply for the chat
+func (bot *Bot) subscribe(chat *telebot.Chat, threadID int, filter annotationFilter) (string, error) {
+	exist, err := bot.store.ExistChat(chat, threadID)
+
+	if err != nil {
+		level.Error(bot.logger).Log("msg", "Could check key in store", "err", err)
+		return "", err
+	}
+
 	if exist {
-		level.Warn(bot.logger).Log("msg", "Chat already subscribed for tags", "chat", m.Chat.ID)
-		chatTags, err := bot.store.GetChatTags(m.Chat, m.ThreadID)
+		level.Warn(bot.logger).Log("msg", "Chat already subscribed for tags", "chat", chat.ID)
+		subscription, err := bot.store.GetChat(chat, threadID)
 
 		if err != nil {
-			bot.tb.Send(
-				m.Chat,
-				"You're already subscribed for tags\n\nUnsubscribe first",
-				&telebot.SendOptions{ParseMode: telebot.ModeMarkdown, ThreadID: m.ThreadID},
-			)
-			return err
+			return "You're already subscribed for tags\n\nUnsubscribe first", err
 		}
 
-		_, err = bot.tb.Send(
-			m.Chat,
-			fmt.Sprintf("You're already subscribed for tags:\n%v.\n\nUnsubscribe first", chatTags),
-			&telebot.SendOptions{ThreadID: m.ThreadID},
-		)
+		return fmt.Sprintf("You're already subscribed for:\n%s\n\nUnsubscribe first", filterFromStoreValue(subscription)), nil
+	}
 
-		return err
+	err = bot.store.AddChat(database.StoreValue{
+		Tags:     filter.Tags,
+		OrgID:    filter.OrgID,
+		Source:   filter.Source,
+		ThreadID: threadID,
+		Chat:     chat,
+		Created:  time.Now(),
+	})
+
+	if err != nil {
+		level.Error(bot.logger).Log("msg", "Could not add chat to store", "err", err)
+		return "", err
+	}
+
+	reply := fmt.Sprintf("You're successfully subscribed for:\n%s", filter)
+
+	if unknown := bot.unknownTags(filter); len(unknown) > 0 {
+		level.Warn(bot.logger).Log("msg", "Chat subscribed for unknown tags", "chat", chat.ID, "tags", strings.Join(unknown, ","))
+		reply = fmt.Sprintf("%s\n\n%s", reply, unknownTagsWarning(unknown))
 	}
 
-	tags := strings.Split(m.Payload, ",")
-	bot.store.AddChatTags(m.Chat, m.ThreadID, tags)
-	_, err = bot.tb.Send(
-		m.Chat,
-		fmt.Sprintf("You're successfully subscribed for tags:\n%v", tags),
-		&telebot.SendOptions{ParseMode: telebot.ModeMarkdown, ThreadID: m.ThreadID},
-	)
-	return err
+	return reply, nil
 }
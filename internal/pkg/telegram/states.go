@@ -0,0 +1,23 @@
+package telegram
+
+import "strings"
+
+// Annotation states of legacy alerting, unified alerting writes capitalized ones with the reason,
+// e.g. "Alerting (Error)"
+const (
+	stateAlerting = "alerting"
+	stateOK       = "ok"
+	stateNormal   = "normal"
+)
+
+// isAlertingState : alerting state of legacy or unified alerting, including alerting on errors and no data
+func isAlertingState(state string) bool {
+	return strings.HasPrefix(strings.ToLower(state), stateAlerting)
+}
+
+// isOKState : ok state of legacy alerting or normal state of unified alerting
+func isOKState(state string) bool {
+	state = strings.ToLower(state)
+
+	return strings.HasPrefix(state, stateOK) || strings.HasPrefix(state, stateNormal)
+}
@@ -0,0 +1,357 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	app "github.com/zt-sv/grafana-annotations-bot/internal/app/grafana-annotations-bot"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/grafana"
+	"gopkg.in/telebot.v3"
+)
+
+const (
+	statsKeyGroupBy     = "groupby"
+	defaultStatsPeriod  = 7 * 24 * time.Hour
+	maxStatsAnnotations = historyScanLimit
+	statsTopSize        = 10
+)
+
+// DefaultStatsTemplate : template of /stats and scheduled reports
+const DefaultStatsTemplate = `<b>{{.Title}}</b>
+{{.From.Format "Mon, 02 Jan 2006 15:04"}} - {{.To.Format "Mon, 02 Jan 2006 15:04 MST"}}
+Annotations: {{.Total}}{{if .Truncated}}, the oldest ones are not counted{{end}}
+
+<b>Top by {{.GroupBy}}</b>{{range .Groups}}
+{{.Count}} {{if .URL}}<a href="{{.URL}}">{{.Name}}</a>{{else}}{{.Name}}{{end}}{{else}}
+No annotations{{end}}{{if .Rules}}
+
+<b>Alert rules</b>{{range .Rules}}
+{{.Name}}: {{.Transitions}} transitions, {{.Alerting}} alerting{{if .MeanTimeToRecover}}, mean time to ok {{.MeanTimeToRecover}}{{end}}{{end}}{{if .MeanTimeToRecover}}
+
+Mean time between alerting and ok: {{.MeanTimeToRecover}}{{end}}{{end}}`
+
+// statsGroup : number of annotations with the tag, on the dashboard or by the user
+type statsGroup struct {
+	Name  string
+	URL   string
+	Count int
+	key   string
+}
+
+// ruleStats : state transitions of the alert rule
+type ruleStats struct {
+	Name              string
+	Transitions       int
+	Alerting          int
+	MeanTimeToRecover time.Duration
+	recovered         int
+	recoveryTime      time.Duration
+}
+
+// statsData : stats template data
+type statsData struct {
+	Title             string
+	From              time.Time
+	To                time.Time
+	GroupBy           string
+	Total             int
+	Truncated         bool
+	Groups            []statsGroup
+	Rules             []ruleStats
+	MeanTimeToRecover time.Duration
+}
+
+// handleStats : /stats [period] [groupby=tag|dashboard|user] [filter]
+func (bot *Bot) handleStats(m *telebot.Message) error {
+	period := defaultStatsPeriod
+	groupBy := app.GroupByTag
+	payload := strings.Fields(m.Payload)
+
+	if len(payload) > 0 {
+		if d, err := parseDuration(payload[0]); err == nil {
+			period = d
+			payload = payload[1:]
+		}
+	}
+
+	var filterFields []string
+
+	for _, field := range payload {
+		if value, found := strings.CutPrefix(field, statsKeyGroupBy+"="); found {
+			groupBy = value
+			continue
+		}
+
+		filterFields = append(filterFields, field)
+	}
+
+	filter, err := bot.parseFilter(strings.Join(filterFields, " "))
+
+	if err == nil && !isGroupBy(groupBy) {
+		err = fmt.Errorf("invalid groupby %q, must be one of %s, %s, %s", groupBy, app.GroupByTag, app.GroupByDashboard, app.GroupByUser)
+	}
+
+	if err != nil {
+		_, err := bot.tb.Send(
+			m.Chat,
+			fmt.Sprintf("Invalid request: %v\n\nExample:\n/stats\n/stats 30d groupby=dashboard source=prod", err),
+			&telebot.SendOptions{ThreadID: m.ThreadID},
+		)
+		return err
+	}
+
+	title := "Annotations statistics"
+
+	if !filter.isEmpty() {
+		title = fmt.Sprintf("%s, %s", title, filter)
+	}
+
+	text, err := bot.renderStats(title, filter, period, groupBy)
+
+	if err != nil {
+		level.Error(bot.logger).Log("msg", "failed to render statistics", "err", err)
+		_, err = bot.tb.Send(m.Chat, "Failed to render statistics: "+err.Error(), &telebot.SendOptions{ThreadID: m.ThreadID})
+		return err
+	}
+
+	_, err = bot.sendHTML(
+		m.Chat,
+		text,
+		&telebot.SendOptions{ThreadID: m.ThreadID, DisableWebPagePreview: true},
+	)
+
+	return err
+}
+
+func isGroupBy(groupBy string) bool {
+	return groupBy == app.GroupByTag || groupBy == app.GroupByDashboard || groupBy == app.GroupByUser
+}
+
+// renderStats : collect statistics of the period until now and render them with the stats template
+func (bot *Bot) renderStats(title string, filter annotationFilter, period time.Duration, groupBy string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), grafanaRequestTimeout)
+	defer cancel()
+
+	to := time.Now()
+	from := to.Add(-period)
+	annotations, unavailable := bot.findAnnotations(ctx, annotationSearch{filter: filter}, from, to, maxStatsAnnotations)
+
+	if len(unavailable) > 0 {
+		title = fmt.Sprintf("%s\nUnavailable: %s", title, strings.Join(unavailable, ", "))
+	}
+
+	data := statsData{
+		Title:     title,
+		From:      from,
+		To:        to,
+		GroupBy:   groupBy,
+		Total:     len(annotations),
+		Truncated: len(annotations) >= maxStatsAnnotations,
+		Groups:    bot.groupAnnotations(ctx, annotations, groupBy, from, to),
+	}
+	data.Rules, data.MeanTimeToRecover = alertRuleStats(annotations)
+
+	var text bytes.Buffer
+
+	if err := bot.statsTemplate.Execute(&text, &data); err != nil {
+		return "", err
+	}
+
+	return text.String(), nil
+}
+
+// groupAnnotations : top groups by number of annotations
+func (bot *Bot) groupAnnotations(ctx context.Context, annotations []grafana.Annotation, groupBy string, from time.Time, to time.Time) []statsGroup {
+	groups := map[string]*statsGroup{}
+
+	add := func(key string, name string) {
+		if _, ok := groups[key]; !ok {
+			groups[key] = &statsGroup{Name: name, key: key}
+		}
+
+		groups[key].Count++
+	}
+
+	for _, annotation := range annotations {
+		switch groupBy {
+		case app.GroupByTag:
+			for _, tag := range annotation.Tags {
+				add(tag, tag)
+			}
+
+		case app.GroupByDashboard:
+			if annotation.DashboardUID == "" {
+				add("", "Organization annotations")
+				continue
+			}
+
+			add(fmt.Sprintf("%s/%d/%s", annotation.Source, annotation.OrgID, annotation.DashboardUID), annotation.DashboardUID)
+
+		case app.GroupByUser:
+			switch {
+			case annotation.Login != "":
+				add(annotation.Login, annotation.Login)
+			case annotation.UserID != 0:
+				add("user "+strconv.Itoa(annotation.UserID), "user "+strconv.Itoa(annotation.UserID))
+			default:
+				add("", "Grafana")
+			}
+		}
+	}
+
+	top := make([]statsGroup, 0, len(groups))
+
+	for _, group := range groups {
+		top = append(top, *group)
+	}
+
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+
+		return top[i].Name < top[j].Name
+	})
+
+	if len(top) > statsTopSize {
+		top = top[:statsTopSize]
+	}
+
+	if groupBy == app.GroupByDashboard {
+		for i := range top {
+			if top[i].key != "" {
+				bot.describeDashboard(ctx, &top[i], from, to)
+			}
+		}
+	}
+
+	return top
+}
+
+// describeDashboard : set title and link of the dashboard group, the key is "<source>/<org>/<uid>"
+func (bot *Bot) describeDashboard(ctx context.Context, group *statsGroup, from time.Time, to time.Time) {
+	parts := strings.SplitN(group.key, "/", 3)
+	orgID, _ := strconv.ParseInt(parts[1], 10, 64)
+	instance, ok := bot.instanceForSource(parts[0])
+
+	if !ok {
+		return
+	}
+
+	client := bot.grafana[instance].Client.WithOrg(orgID)
+	group.URL = client.DashboardRangeURL(parts[2], orgID, from, to)
+
+	if title, err := client.GetDashboardTitle(ctx, parts[2]); err == nil && title != "" {
+		group.Name = title
+	}
+}
+
+// alertRuleStats : state transitions per alert rule and mean time from alerting to ok
+func alertRuleStats(annotations []grafana.Annotation) ([]ruleStats, time.Duration) {
+	rules := map[string]*ruleStats{}
+	alertingSince := map[string]int64{}
+
+	var (
+		recovered    int
+		recoveryTime time.Duration
+	)
+
+	// Annotations are sorted from the newest one
+	for i := len(annotations) - 1; i >= 0; i-- {
+		annotation := annotations[i]
+
+		if annotation.NewState == "" {
+			continue
+		}
+
+		key := alertRuleKey(annotation)
+		rule, ok := rules[key]
+
+		if !ok {
+			rule = &ruleStats{Name: alertRuleName(annotation)}
+			rules[key] = rule
+		}
+
+		rule.Transitions++
+
+		// Multi-dimensional rules change state of every label set separately
+		seriesKey := fmt.Sprintf("%s%v", key, annotation.Labels)
+
+		switch {
+		case isAlertingState(annotation.NewState):
+			rule.Alerting++
+
+			if _, ok := alertingSince[seriesKey]; !ok {
+				alertingSince[seriesKey] = annotation.Time
+			}
+
+		case isOKState(annotation.NewState):
+			if since, ok := alertingSince[seriesKey]; ok {
+				duration := time.Duration(annotation.Time-since) * time.Millisecond
+				rule.recovered++
+				rule.recoveryTime += duration
+				recovered++
+				recoveryTime += duration
+				delete(alertingSince, seriesKey)
+			}
+		}
+	}
+
+	top := make([]ruleStats, 0, len(rules))
+
+	for _, rule := range rules {
+		if rule.recovered > 0 {
+			rule.MeanTimeToRecover = (rule.recoveryTime / time.Duration(rule.recovered)).Round(time.Second)
+		}
+
+		top = append(top, *rule)
+	}
+
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Transitions != top[j].Transitions {
+			return top[i].Transitions > top[j].Transitions
+		}
+
+		return top[i].Name < top[j].Name
+	})
+
+	if len(top) > statsTopSize {
+		top = top[:statsTopSize]
+	}
+
+	if recovered == 0 {
+		return top, 0
+	}
+
+	return top, (recoveryTime / time.Duration(recovered)).Round(time.Second)
+}
+
+func alertRuleKey(annotation grafana.Annotation) string {
+	prefix := fmt.Sprintf("%s/%d/", annotation.Source, annotation.OrgID)
+
+	switch {
+	case annotation.AlertUID != "":
+		return prefix + annotation.AlertUID
+	case annotation.AlertID != 0:
+		return prefix + strconv.Itoa(annotation.AlertID)
+	default:
+		return prefix + alertRuleName(annotation)
+	}
+}
+
+func alertRuleName(annotation grafana.Annotation) string {
+	switch {
+	case annotation.AlertName != "":
+		return annotation.AlertName
+	case annotation.Labels["alertname"] != "":
+		return annotation.Labels["alertname"]
+	default:
+		return newTemplateData(annotation).Title()
+	}
+}
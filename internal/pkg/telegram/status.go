@@ -1,43 +1,113 @@
 package telegram
 
 import (
+	"context"
 	"fmt"
+	"html"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-kit/kit/log/level"
 	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/build"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/grafana"
 	telebot "gopkg.in/telebot.v3"
 )
 
 func (bot *Bot) handleStatus(m *telebot.Message) error {
-	grafanaStatus, err := bot.grafanaClient.GetStatus()
+	// Instances are checked in parallel with own timeouts, a slow one does not delay the others
+	grafanaTexts := make([]string, len(bot.grafana))
 
-	if err != nil {
-		level.Warn(bot.logger).Log("msg", "failed to get grafana status", "err", err)
-		_, err2 := bot.tb.Send(
-			m.Chat,
-			fmt.Sprintf("failed to get status... %v", err),
-			&telebot.SendOptions{ParseMode: telebot.ModeMarkdown, ThreadID: m.ThreadID},
-		)
-		if err2 != nil {
-			return err2
-		}
-		return err
+	var wg sync.WaitGroup
+
+	for i, instance := range bot.grafana {
+		wg.Add(1)
+
+		go func(i int, instance GrafanaInstance) {
+			defer wg.Done()
+
+			grafanaTexts[i] = bot.grafanaStatusText(instance)
+		}(i, instance)
 	}
 
-	_, err = bot.tb.Send(
+	wg.Wait()
+
+	_, err := bot.sendHTML(
 		m.Chat,
 		fmt.Sprintf(
-			"*Grafana*\nVersion: %s\nDatabase: %s\n\n*Telegram Bot*\nVersion: %s\nBuild date: %s\nGo version: %s\nUptime: %s",
-			grafanaStatus.Version,
-			grafanaStatus.Database,
-			build.Version,
-			build.BuildDate,
-			build.GoVersion,
+			"%s\n\n<b>Telegram Bot</b>\nVersion: %s\nBuild date: %s\nGo version: %s\nUptime: %s",
+			strings.Join(grafanaTexts, "\n\n"),
+			html.EscapeString(build.Version),
+			html.EscapeString(build.BuildDate),
+			html.EscapeString(build.GoVersion),
 			bot.startTime.Format(time.RFC1123),
 		),
-		&telebot.SendOptions{ParseMode: telebot.ModeMarkdown, ThreadID: m.ThreadID},
+		&telebot.SendOptions{ThreadID: m.ThreadID},
 	)
 
 	return err
 }
+
+func (bot *Bot) grafanaStatusText(instance GrafanaInstance) string {
+	ctx, cancel := context.WithTimeout(context.Background(), grafanaRequestTimeout)
+	defer cancel()
+
+	title := "<b>Grafana</b>"
+
+	if len(bot.grafana) > 1 {
+		title = fmt.Sprintf("<b>Grafana %s</b>", html.EscapeString(instance.Name))
+	}
+
+	// Grafana serves health without authentication, so scrape failures, e.g. of a revoked token, are shown as well
+	grafanaStatus, err := instance.Client.GetStatus(ctx)
+	scrapeText := scrapeStateText(instance)
+
+	if err != nil {
+		level.Warn(bot.logger).Log("msg", "failed to get grafana status", "grafana", instance.Name, "err", err)
+
+		downSince := time.Now()
+
+		if since, down := instance.State.DownSince(); down {
+			downSince = since
+		}
+
+		return fmt.Sprintf(
+			"%s\nUnreachable since %s\n%s\nError: <code>%s</code>%s",
+			title,
+			downSince.Format(time.RFC1123),
+			html.EscapeString(grafana.Describe(err)),
+			html.EscapeString(err.Error()),
+			scrapeText,
+		)
+	}
+
+	if scrapeText != "" {
+		title += " ⚠️ degraded"
+	}
+
+	return fmt.Sprintf(
+		"%s\nVersion: %s\nDatabase: %s%s",
+		title,
+		html.EscapeString(grafanaStatus.Version),
+		html.EscapeString(grafanaStatus.Database),
+		scrapeText,
+	)
+}
+
+// scrapeStateText : failures of the scrapes recorded by the poller, empty when the scrapes succeed
+func scrapeStateText(instance GrafanaInstance) string {
+	since, down := instance.State.DownSince()
+	lastErr := instance.State.LastError()
+
+	if !down || lastErr == nil {
+		return ""
+	}
+
+	return fmt.Sprintf(
+		"\nScrapes failing since %s, %d failures\n%s\nLast error: <code>%s</code>",
+		since.Format(time.RFC1123),
+		instance.State.Failures(),
+		html.EscapeString(grafana.Describe(lastErr)),
+		html.EscapeString(lastErr.Error()),
+	)
+}
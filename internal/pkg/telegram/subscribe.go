@@ -0,0 +1,447 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/grafana"
+	"gopkg.in/telebot.v3"
+)
+
+const (
+	buttonSubscribe = "subscribe"
+
+	subscribeActionSource  = "src"
+	subscribeActionTag     = "tag"
+	subscribeActionPage    = "page"
+	subscribeActionSearch  = "search"
+	subscribeActionClear   = "clear"
+	subscribeActionPreview = "preview"
+	subscribeActionBack    = "back"
+	subscribeActionSave    = "save"
+	subscribeActionCancel  = "cancel"
+
+	subscribeStepSource  = "source"
+	subscribeStepTags    = "tags"
+	subscribeStepPreview = "preview"
+
+	subscribeWizardTTL = time.Hour
+	// Tags requested from Grafana at once
+	subscribeTagsLimit  = 100
+	subscribeTagsOnPage = 8
+	subscribePreviewLen = 5
+)
+
+// subscribeWizard : state of /subscribe wizard, the wizard edits a single message
+type subscribeWizard struct {
+	mu        sync.Mutex
+	key       string
+	chat      *telebot.Chat
+	threadID  int
+	messageID int
+	step      string
+	filter    annotationFilter
+	search    string
+	tags      []grafana.TagCount
+	page      int
+	tagsErr   error
+	closed    bool
+}
+
+// handleSubscribe : /subscribe [filter], build subscription filter with buttons
+func (bot *Bot) handleSubscribe(m *telebot.Message) error {
+	filter, err := bot.parseFilter(m.Payload)
+
+	if err != nil {
+		_, err := bot.tb.Send(
+			m.Chat,
+			fmt.Sprintf("Invalid filter: %v\n\nExample:\n/subscribe\n/subscribe source=prod org=3", err),
+			&telebot.SendOptions{ThreadID: m.ThreadID},
+		)
+		return err
+	}
+
+	wizard := &subscribeWizard{
+		key:      randomKey(),
+		chat:     m.Chat,
+		threadID: m.ThreadID,
+		step:     subscribeStepTags,
+		filter:   filter,
+	}
+
+	if filter.Source == "" && len(bot.grafana) > 1 {
+		wizard.step = subscribeStepSource
+	} else {
+		bot.loadWizardTags(wizard)
+	}
+
+	text, markup := bot.renderSubscribeWizard(wizard)
+	message, err := bot.tb.Send(
+		m.Chat,
+		text,
+		&telebot.SendOptions{ParseMode: telebot.ModeHTML, ThreadID: m.ThreadID, ReplyMarkup: markup},
+	)
+
+	if err != nil {
+		return err
+	}
+
+	wizard.messageID = message.ID
+	bot.wizards.put(wizard.key, wizard)
+
+	return nil
+}
+
+func (bot *Bot) handleSubscribeButton(c telebot.Context) error {
+	args := c.Args()
+
+	if len(args) < 2 {
+		return c.Respond(&telebot.CallbackResponse{Text: "Bad button"})
+	}
+
+	wizard, ok := bot.wizards.get(args[0])
+
+	if !ok {
+		return c.Respond(&telebot.CallbackResponse{Text: "The wizard expired, start it again with /subscribe", ShowAlert: true})
+	}
+
+	wizard.mu.Lock()
+	defer wizard.mu.Unlock()
+
+	if wizard.closed {
+		return c.Respond(&telebot.CallbackResponse{Text: "The wizard is already finished"})
+	}
+
+	arg := -1
+
+	if len(args) > 2 {
+		arg, _ = strconv.Atoi(args[2])
+	}
+
+	switch args[1] {
+	case subscribeActionSource:
+		if arg >= 0 && arg < len(bot.grafana) {
+			wizard.filter.Source = bot.grafana[arg].Name
+		}
+
+		wizard.step = subscribeStepTags
+		bot.loadWizardTags(wizard)
+
+	case subscribeActionTag:
+		if arg >= 0 && arg < len(wizard.tags) {
+			wizard.filter.Tags = toggleTag(wizard.filter.Tags, wizard.tags[arg].Tag)
+		}
+
+	case subscribeActionPage:
+		wizard.page = arg
+
+	case subscribeActionSearch:
+		return bot.askWizardSearch(c, wizard)
+
+	case subscribeActionClear:
+		wizard.search = ""
+		bot.loadWizardTags(wizard)
+
+	case subscribeActionPreview:
+		if wizard.filter.isEmpty() {
+			return c.Respond(&telebot.CallbackResponse{Text: "Choose at least one tag"})
+		}
+
+		wizard.step = subscribeStepPreview
+
+	case subscribeActionBack:
+		wizard.step = subscribeStepTags
+
+	case subscribeActionSave:
+		bot.closeWizard(wizard)
+		reply, err := bot.subscribe(wizard.chat, wizard.threadID, wizard.filter)
+
+		if err != nil {
+			c.Respond(&telebot.CallbackResponse{Text: "Something went wrong...", ShowAlert: true})
+			return err
+		}
+
+		c.Respond()
+
+		return c.Edit(reply)
+
+	case subscribeActionCancel:
+		bot.closeWizard(wizard)
+		c.Respond()
+
+		return c.Edit("Subscription is canceled")
+	}
+
+	if err := c.Respond(); err != nil {
+		level.Warn(bot.logger).Log("msg", "failed to answer callback", "err", err)
+	}
+
+	text, markup := bot.renderSubscribeWizard(wizard)
+
+	if err := c.Edit(text, &telebot.SendOptions{ParseMode: telebot.ModeHTML, ReplyMarkup: markup}); err != nil {
+		return err
+	}
+
+	if wizard.step == subscribeStepPreview && args[1] == subscribeActionPreview {
+		return bot.sendSubscriptionPreview(wizard)
+	}
+
+	return nil
+}
+
+// askWizardSearch : ask for the tags search text, the reply to the question is handled by handleWizardReply
+//
+// The question is not selective, it replies to nobody, so every chat member is asked to reply.
+func (bot *Bot) askWizardSearch(c telebot.Context, wizard *subscribeWizard) error {
+	c.Respond()
+
+	question, err := bot.tb.Send(
+		wizard.chat,
+		"Reply with text to search tags",
+		&telebot.SendOptions{ThreadID: wizard.threadID, ReplyMarkup: &telebot.ReplyMarkup{ForceReply: true}},
+	)
+
+	if err != nil {
+		return err
+	}
+
+	bot.wizardQuestions.put(wizardQuestionKey(wizard.chat.ID, question.ID), wizard.key)
+
+	return nil
+}
+
+func (bot *Bot) closeWizard(wizard *subscribeWizard) {
+	wizard.closed = true
+	bot.wizards.delete(wizard.key)
+}
+
+func wizardQuestionKey(chatID int64, messageID int) string {
+	return fmt.Sprintf("%d/%d", chatID, messageID)
+}
+
+// handleWizardReply : set tags search text of the wizard, other text messages are ignored
+func (bot *Bot) handleWizardReply(c telebot.Context) error {
+	m := c.Message()
+
+	if m.ReplyTo == nil {
+		return nil
+	}
+
+	key, ok := bot.wizardQuestions.get(wizardQuestionKey(m.Chat.ID, m.ReplyTo.ID))
+
+	if !ok || m.Sender == nil || !bot.isAdminID(m.Sender.ID) {
+		return nil
+	}
+
+	wizard, ok := bot.wizards.get(key)
+
+	if !ok {
+		return nil
+	}
+
+	wizard.mu.Lock()
+	defer wizard.mu.Unlock()
+
+	if wizard.closed {
+		return nil
+	}
+
+	wizard.search = strings.TrimSpace(m.Text)
+	wizard.step = subscribeStepTags
+	bot.loadWizardTags(wizard)
+
+	text, markup := bot.renderSubscribeWizard(wizard)
+	_, err := bot.tb.Edit(
+		&telebot.StoredMessage{MessageID: strconv.Itoa(wizard.messageID), ChatID: wizard.chat.ID},
+		text,
+		&telebot.SendOptions{ParseMode: telebot.ModeHTML, ReplyMarkup: markup},
+	)
+
+	return err
+}
+
+// loadWizardTags : load tags matching the search from the selected Grafana instances
+func (bot *Bot) loadWizardTags(wizard *subscribeWizard) {
+	ctx, cancel := context.WithTimeout(context.Background(), grafanaRequestTimeout)
+	defer cancel()
+
+	counts := map[string]int{}
+	wizard.tagsErr = nil
+
+	for _, instance := range bot.grafana {
+		if wizard.filter.Source != "" && wizard.filter.Source != instance.Name {
+			continue
+		}
+
+		tags, err := instance.Client.WithOrg(wizard.filter.OrgID).GetAnnotationTags(ctx, wizard.search, subscribeTagsLimit)
+
+		if err != nil {
+			level.Warn(bot.logger).Log("msg", "failed to get annotation tags", "grafana", instance.Name, "err", err)
+			wizard.tagsErr = err
+			continue
+		}
+
+		for _, tag := range tags {
+			counts[tag.Tag] += tag.Count
+		}
+	}
+
+	wizard.tags = make([]grafana.TagCount, 0, len(counts))
+
+	for tag, count := range counts {
+		wizard.tags = append(wizard.tags, grafana.TagCount{Tag: tag, Count: count})
+	}
+
+	sort.Slice(wizard.tags, func(i, j int) bool {
+		if wizard.tags[i].Count != wizard.tags[j].Count {
+			return wizard.tags[i].Count > wizard.tags[j].Count
+		}
+
+		return wizard.tags[i].Tag < wizard.tags[j].Tag
+	})
+
+	wizard.page = 0
+}
+
+func (bot *Bot) renderSubscribeWizard(wizard *subscribeWizard) (string, *telebot.ReplyMarkup) {
+	markup := &telebot.ReplyMarkup{}
+	button := func(text string, action string, arg int) telebot.Btn {
+		return markup.Data(text, buttonSubscribe, wizard.key, action, strconv.Itoa(arg))
+	}
+
+	var (
+		lines = []string{"<b>New subscription</b>"}
+		rows  []telebot.Row
+	)
+
+	if wizard.filter.Source != "" {
+		lines = append(lines, "Grafana: "+html.EscapeString(wizard.filter.Source))
+	}
+
+	if wizard.filter.OrgID != 0 {
+		lines = append(lines, fmt.Sprintf("Organization: %d", wizard.filter.OrgID))
+	}
+
+	if len(wizard.filter.Tags) > 0 {
+		lines = append(lines, "Tags: "+html.EscapeString(strings.Join(wizard.filter.Tags, ", ")))
+	}
+
+	switch wizard.step {
+	case subscribeStepSource:
+		lines = append(lines, "", "Choose Grafana instance")
+
+		for i, instance := range bot.grafana {
+			rows = append(rows, markup.Row(button(instance.Name, subscribeActionSource, i)))
+		}
+
+		rows = append(rows, markup.Row(button("Any", subscribeActionSource, -1)))
+
+	case subscribeStepTags:
+		if wizard.search != "" {
+			lines = append(lines, "Search: "+html.EscapeString(wizard.search))
+		}
+
+		lines = append(lines, "", "Choose tags, annotations must have all of them")
+
+		if wizard.tagsErr != nil {
+			lines = append(lines, "Failed to get some tags: "+html.EscapeString(grafana.Describe(wizard.tagsErr)))
+		}
+
+		if len(wizard.tags) == 0 {
+			lines = append(lines, "No tags found")
+		}
+
+		pages := (len(wizard.tags) + subscribeTagsOnPage - 1) / subscribeTagsOnPage
+		start := wizard.page * subscribeTagsOnPage
+		var tagButtons []telebot.Btn
+
+		for i := start; i < start+subscribeTagsOnPage && i < len(wizard.tags); i++ {
+			text := fmt.Sprintf("%s (%d)", wizard.tags[i].Tag, wizard.tags[i].Count)
+
+			if tagInList(wizard.tags[i].Tag, wizard.filter.Tags) {
+				text = "✅ " + text
+			}
+
+			tagButtons = append(tagButtons, button(text, subscribeActionTag, i))
+		}
+
+		rows = append(rows, markup.Split(2, tagButtons)...)
+
+		var navigation []telebot.Btn
+
+		if wizard.page > 0 {
+			navigation = append(navigation, button("‹ Prev", subscribeActionPage, wizard.page-1))
+		}
+
+		if wizard.page+1 < pages {
+			navigation = append(navigation, button("Next ›", subscribeActionPage, wizard.page+1))
+		}
+
+		if len(navigation) > 0 {
+			rows = append(rows, markup.Row(navigation...))
+		}
+
+		search := button("🔍 Search", subscribeActionSearch, 0)
+
+		if wizard.search != "" {
+			rows = append(rows, markup.Row(search, button("Clear search", subscribeActionClear, 0)))
+		} else {
+			rows = append(rows, markup.Row(search))
+		}
+
+		rows = append(rows, markup.Row(button("Preview", subscribeActionPreview, 0), button("Cancel", subscribeActionCancel, 0)))
+
+	case subscribeStepPreview:
+		lines = append(lines, "", "Recent matching annotations are sent below, subscribe?")
+		rows = append(rows, markup.Row(
+			button("✅ Subscribe", subscribeActionSave, 0),
+			button("‹ Back", subscribeActionBack, 0),
+			button("Cancel", subscribeActionCancel, 0),
+		))
+	}
+
+	markup.Inline(rows...)
+
+	return strings.Join(lines, "\n"), markup
+}
+
+// sendSubscriptionPreview : send recent annotations matching the wizard filter with the list template
+func (bot *Bot) sendSubscriptionPreview(wizard *subscribeWizard) error {
+	ctx, cancel := context.WithTimeout(context.Background(), grafanaRequestTimeout)
+	defer cancel()
+
+	annotations, unavailable := bot.findAnnotations(ctx, annotationSearch{filter: wizard.filter}, time.Time{}, time.Time{}, subscribePreviewLen)
+	title := "Recent matching annotations"
+
+	if len(unavailable) > 0 {
+		title = fmt.Sprintf("%s\nUnavailable: %s", title, strings.Join(unavailable, ", "))
+	}
+
+	text, _, err := bot.renderHistoryPage(historyResult{title: title, annotations: annotations}, "", 1)
+
+	if err != nil {
+		level.Error(bot.logger).Log("msg", "failed to render annotations list", "err", err)
+		text = "Failed to render annotations list: " + html.EscapeString(err.Error())
+	}
+
+	_, err = bot.sendHTML(wizard.chat, text, &telebot.SendOptions{ThreadID: wizard.threadID})
+
+	return err
+}
+
+func toggleTag(tags []string, tag string) []string {
+	for i, t := range tags {
+		if t == tag {
+			return append(tags[:i:i], tags[i+1:]...)
+		}
+	}
+
+	return append(tags, tag)
+}
@@ -0,0 +1,236 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	app "github.com/zt-sv/grafana-annotations-bot/internal/app/grafana-annotations-bot"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/database"
+	"gopkg.in/telebot.v3"
+)
+
+const (
+	// Last matched time of subscriptions is saved at most once per the resolution
+	lastMatchedResolution     = time.Hour
+	deadSubscriptionsInterval = 6 * time.Hour
+	tagValidationLimit        = 1000
+)
+
+// SubscriptionOptions : dead subscriptions check config
+type SubscriptionOptions struct {
+	// DeadAfter : report subscriptions without matched annotations for this long, 0 disables
+	DeadAfter time.Duration
+	// Notify : whom to report dead subscriptions to, one of app.NotifyAdmins and app.NotifyChat
+	Notify string
+}
+
+// unknownTags : tags of the filter Grafana has no annotations with, nil when Grafana is unavailable
+func (bot *Bot) unknownTags(filter annotationFilter) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), grafanaRequestTimeout)
+	defer cancel()
+
+	known := map[string]bool{}
+	checked := false
+
+	for _, instance := range bot.grafana {
+		if filter.Source != "" && filter.Source != instance.Name {
+			continue
+		}
+
+		client := instance.Client.WithOrg(filter.OrgID)
+		failed := false
+
+		for _, tag := range filter.Tags {
+			// Grafana searches key:value tags by the key
+			search, _, _ := strings.Cut(tag, ":")
+			tags, err := client.GetAnnotationTags(ctx, search, tagValidationLimit)
+
+			if err != nil {
+				failed = true
+				break
+			}
+
+			for _, t := range tags {
+				known[t.Tag] = true
+			}
+		}
+
+		checked = checked || !failed
+	}
+
+	if !checked {
+		return nil
+	}
+
+	var unknown []string
+
+	for _, tag := range filter.Tags {
+		if !known[tag] {
+			unknown = append(unknown, tag)
+		}
+	}
+
+	return unknown
+}
+
+func unknownTagsWarning(tags []string) string {
+	return fmt.Sprintf(
+		"⚠️ Grafana has no annotations with tags: %s\nCheck the spelling, only annotations with all the tags are sent",
+		strings.Join(tags, ", "),
+	)
+}
+
+// recordMatch : save the time the subscription matched an annotation
+func (bot *Bot) recordMatch(subscription database.StoreValue, now time.Time) {
+	key := fmt.Sprintf("%d/%d", subscription.Chat.ID, subscription.ThreadID)
+
+	if now.Sub(bot.lastMatched[key]) < lastMatchedResolution {
+		return
+	}
+
+	err := bot.updateSubscriptionState(subscription, func(state *database.SubscriptionState) {
+		state.LastMatched = now
+	})
+
+	if err == nil {
+		bot.lastMatched[key] = now
+	}
+}
+
+func (bot *Bot) checkSubscriptions(stop <-chan struct{}) error {
+	if bot.subscriptions.DeadAfter <= 0 {
+		<-stop
+		return nil
+	}
+
+	ticker := time.NewTicker(deadSubscriptionsInterval)
+	defer ticker.Stop()
+
+	for {
+		bot.reportDeadSubscriptions(time.Now())
+
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// reportDeadSubscriptions : report every subscription once after it stops matching annotations
+func (bot *Bot) reportDeadSubscriptions(now time.Time) {
+	subscriptions, err := bot.store.List()
+	bot.recordStoreResult(err)
+
+	if err != nil {
+		level.Error(bot.logger).Log("msg", "failed to get list of chats", "err", err)
+		return
+	}
+
+	var dead []string
+
+	for _, subscription := range subscriptions {
+		state, err := bot.store.GetSubscriptionState(subscription.Chat, subscription.ThreadID)
+
+		if err != nil {
+			level.Warn(bot.logger).Log("msg", "failed to get subscription state", "chat", subscription.Chat.ID, "err", err)
+			continue
+		}
+
+		since := state.LastMatched
+
+		if subscription.Created.After(since) {
+			since = subscription.Created
+		}
+
+		if since.IsZero() {
+			// Subscriptions created by older versions are counted from now
+			bot.updateSubscriptionState(subscription, func(state *database.SubscriptionState) {
+				if state.LastMatched.IsZero() {
+					state.LastMatched = now
+				}
+			})
+			continue
+		}
+
+		if now.Sub(since) < bot.subscriptions.DeadAfter || state.Notified.After(since) {
+			continue
+		}
+
+		filter := filterFromStoreValue(subscription)
+		days := int(now.Sub(since).Hours() / 24)
+		level.Info(bot.logger).Log("msg", "subscription is dead", "chat", subscription.Chat.ID, "filter", filter, "since", since)
+
+		if bot.subscriptions.Notify == app.NotifyChat {
+			text := fmt.Sprintf(
+				"This chat got no annotations for %d days, the subscription:\n%s\n\nUse /stop to unsubscribe",
+				days,
+				filter,
+			)
+
+			if unknown := bot.unknownTags(filter); len(unknown) > 0 {
+				text = fmt.Sprintf("%s\n\n%s", text, unknownTagsWarning(unknown))
+			}
+
+			_, err := bot.tb.Send(subscription.Chat, text, &telebot.SendOptions{ThreadID: subscription.ThreadID})
+			bot.delivery.record(err)
+
+			if err != nil {
+				level.Error(bot.logger).Log("msg", "failed to report dead subscription", "chat", subscription.Chat.ID, "err", err)
+				continue
+			}
+		} else {
+			dead = append(dead, html.EscapeString(fmt.Sprintf("%s: %s, %d days", chatName(subscription.Chat), filter, days)))
+		}
+
+		bot.updateSubscriptionState(subscription, func(state *database.SubscriptionState) {
+			state.Notified = now
+		})
+	}
+
+	if len(dead) > 0 {
+		bot.notifyPipelineAdmins(fmt.Sprintf(
+			"💤 <b>Subscriptions without annotations</b>\n%s",
+			strings.Join(dead, "\n"),
+		))
+	}
+}
+
+// updateSubscriptionState : read, update and save the subscription state
+//
+// The listener and the dead subscriptions check update different fields of the same state, the updates are
+// serialized to not overwrite each other.
+func (bot *Bot) updateSubscriptionState(subscription database.StoreValue, update func(state *database.SubscriptionState)) error {
+	bot.subscriptionStateMu.Lock()
+	defer bot.subscriptionStateMu.Unlock()
+
+	state, err := bot.store.GetSubscriptionState(subscription.Chat, subscription.ThreadID)
+
+	if err == nil {
+		update(&state)
+		err = bot.store.SaveSubscriptionState(subscription.Chat, subscription.ThreadID, state)
+	}
+
+	bot.recordStoreResult(err)
+
+	if err != nil {
+		level.Warn(bot.logger).Log("msg", "failed to save subscription state", "chat", subscription.Chat.ID, "err", err)
+	}
+
+	return err
+}
+
+func chatName(chat *telebot.Chat) string {
+	switch {
+	case chat.Title != "":
+		return fmt.Sprintf("%s (%d)", chat.Title, chat.ID)
+	case chat.Username != "":
+		return fmt.Sprintf("@%s (%d)", chat.Username, chat.ID)
+	default:
+		return fmt.Sprintf("%d", chat.ID)
+	}
+}
@@ -0,0 +1,400 @@
+package telegram
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	app "github.com/zt-sv/grafana-annotations-bot/internal/app/grafana-annotations-bot"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/database"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/telegramhtml"
+	"gopkg.in/telebot.v3"
+)
+
+const (
+	templateActionList = "list"
+	templateActionSet  = "set"
+	templateActionShow = "show"
+)
+
+// ReloadOptions : message templates reload config
+type ReloadOptions struct {
+	// TemplatePath : file of the default message template, no reload when empty
+	TemplatePath string
+	// TemplatesDir : directory of named message templates
+	TemplatesDir string
+	// Interval : how often template files are checked for changes, 0 disables
+	Interval time.Duration
+}
+
+// templateSet : message templates by name
+type templateSet struct {
+	mu        sync.RWMutex
+	templates map[string]app.MessageTemplate
+}
+
+func newTemplateSet(templates map[string]app.MessageTemplate) *templateSet {
+	return &templateSet{templates: templates}
+}
+
+// set : replace all the templates
+func (s *templateSet) set(templates map[string]app.MessageTemplate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.templates = templates
+}
+
+// get : get the template by name, the default template for empty name
+func (s *templateSet) get(name string) (app.MessageTemplate, bool) {
+	if name == "" {
+		name = app.DefaultTemplateName
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tpl, ok := s.templates[name]
+
+	return tpl, ok
+}
+
+// names : template names, the default one first
+func (s *templateSet) names() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0, len(s.templates))
+
+	for name := range s.templates {
+		if name != app.DefaultTemplateName {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return append([]string{app.DefaultTemplateName}, names...)
+}
+
+// renderedMessage : annotation message rendered with the template and the message options of the template
+type renderedMessage struct {
+	text     string
+	options  app.MessageOptions
+	keyboard [][]telebot.InlineButton
+}
+
+// renderAnnotation : render the annotation message and the keyboard with the named template
+//
+// Subscriptions of removed templates get the default one.
+func (bot *Bot) renderAnnotation(name string, data *templateData) (renderedMessage, error) {
+	tpl, ok := bot.templates.get(name)
+
+	if !ok {
+		level.Warn(bot.logger).Log("msg", "template not found, use the default one", "template", name)
+		tpl, _ = bot.templates.get("")
+	}
+
+	var text bytes.Buffer
+
+	if err := tpl.Template.Execute(&text, data); err != nil {
+		return renderedMessage{}, err
+	}
+
+	message := renderedMessage{text: text.String(), options: tpl.Options}
+
+	for _, row := range tpl.Options.Keyboard {
+		var buttons []telebot.InlineButton
+
+		for _, button := range row {
+			text, url, err := button.Render(data)
+
+			if err != nil {
+				return renderedMessage{}, fmt.Errorf("keyboard button %q: %w", button.Text, err)
+			}
+
+			// Buttons without URL, e.g. of annotations without dashboard, are skipped
+			if text != "" && url != "" {
+				buttons = append(buttons, telebot.InlineButton{Text: text, URL: url})
+			}
+		}
+
+		if len(buttons) > 0 {
+			message.keyboard = append(message.keyboard, buttons)
+		}
+	}
+
+	return message, nil
+}
+
+// parseMode : Telegram parse mode of the template
+func (m renderedMessage) parseMode() telebot.ParseMode {
+	switch m.options.ParseMode {
+	case app.ParseModeMarkdownV2:
+		return telebot.ModeMarkdownV2
+	case app.ParseModePlain:
+		return telebot.ModeDefault
+	default:
+		return telebot.ModeHTML
+	}
+}
+
+// inlineText : message text for inline query results, they are not split
+func (m renderedMessage) inlineText() string {
+	if m.parseMode() == telebot.ModeHTML {
+		return telegramhtml.Sanitize(m.text)
+	}
+
+	return m.text
+}
+
+// markup : template keyboard above the bot buttons, e.g. silence ones
+func (m renderedMessage) markup(botMarkup *telebot.ReplyMarkup) *telebot.ReplyMarkup {
+	if len(m.keyboard) == 0 {
+		return botMarkup
+	}
+
+	markup := &telebot.ReplyMarkup{InlineKeyboard: append([][]telebot.InlineButton{}, m.keyboard...)}
+
+	if botMarkup != nil {
+		markup.InlineKeyboard = append(markup.InlineKeyboard, botMarkup.InlineKeyboard...)
+	}
+
+	return markup
+}
+
+// handleTemplate : /template list|set <name>|show [name]
+func (bot *Bot) handleTemplate(m *telebot.Message) error {
+	args := strings.Fields(m.Payload)
+	action := templateActionList
+
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	subscription, subscribed, err := bot.chatSubscription(m.Chat, m.ThreadID)
+
+	if err != nil {
+		_, err := bot.tb.Send(m.Chat, err.Error(), &telebot.SendOptions{ThreadID: m.ThreadID})
+		return err
+	}
+
+	current := subscription.Template
+
+	if current == "" {
+		current = app.DefaultTemplateName
+	}
+
+	var text string
+
+	switch {
+	case action == templateActionList && len(args) <= 1:
+		lines := []string{"<b>Message templates</b>"}
+
+		for _, name := range bot.templates.names() {
+			if subscribed && name == current {
+				name = "✅ " + name
+			}
+
+			lines = append(lines, html.EscapeString(name))
+		}
+
+		text = strings.Join(append(lines, "", "Use /template set &lt;name&gt; to choose the template of the chat"), "\n")
+
+	case action == templateActionShow && len(args) <= 2:
+		name := current
+
+		if len(args) == 2 {
+			name = args[1]
+		}
+
+		tpl, ok := bot.templates.get(name)
+
+		if !ok {
+			text = fmt.Sprintf("Unknown template %s, see /template list", html.EscapeString(name))
+			break
+		}
+
+		text = fmt.Sprintf(
+			"<b>%s</b>, parse mode %s\n<pre>%s</pre>",
+			html.EscapeString(name),
+			html.EscapeString(tpl.Options.ParseMode),
+			html.EscapeString(tpl.Text),
+		)
+
+	case action == templateActionSet && len(args) == 2:
+		text, err = bot.setChatTemplate(subscription, subscribed, args[1])
+
+	default:
+		text = "Usage:\n/template list\n/template show [name]\n/template set &lt;name&gt;"
+	}
+
+	// Template source may be longer than a message
+	_, sendErr := bot.sendHTML(m.Chat, text, &telebot.SendOptions{ThreadID: m.ThreadID})
+
+	if err != nil {
+		return err
+	}
+
+	return sendErr
+}
+
+// setChatTemplate : save the template of the chat subscription, returns reply for the chat
+func (bot *Bot) setChatTemplate(subscription database.StoreValue, subscribed bool, name string) (string, error) {
+	if !subscribed {
+		return "The chat is not subscribed, subscribe with /start or /subscribe first", nil
+	}
+
+	if _, ok := bot.templates.get(name); !ok {
+		return fmt.Sprintf("Unknown template %s, see /template list", html.EscapeString(name)), nil
+	}
+
+	subscription.Template = name
+
+	if name == app.DefaultTemplateName {
+		subscription.Template = ""
+	}
+
+	if err := bot.store.AddChat(subscription); err != nil {
+		level.Error(bot.logger).Log("msg", "Could not save chat template", "chat", subscription.Chat.ID, "err", err)
+		return "Something went wrong...", err
+	}
+
+	return fmt.Sprintf("The chat gets messages with template %s, check it with /preview", html.EscapeString(name)), nil
+}
+
+// ReloadTemplates : reload message templates from files, a broken template is reported to admins and
+// the last good templates are kept
+func (bot *Bot) ReloadTemplates(reason string) error {
+	names, err := bot.reloadTemplates()
+
+	if err != nil {
+		level.Error(bot.logger).Log("msg", "failed to reload templates, keep the last good ones", "reason", reason, "err", err)
+		bot.notifyPipelineAdmins(fmt.Sprintf(
+			"⚠️ <b>Templates reload failed</b>\nThe last good templates are kept\n%s",
+			html.EscapeString(err.Error()),
+		))
+		return err
+	}
+
+	level.Info(bot.logger).Log("msg", "templates are reloaded", "reason", reason, "templates", strings.Join(names, ","))
+
+	return nil
+}
+
+// reloadTemplates : load and check message templates, returns names of the loaded templates
+func (bot *Bot) reloadTemplates() ([]string, error) {
+	bot.reloadMu.Lock()
+	defer bot.reloadMu.Unlock()
+
+	if bot.reload.TemplatePath == "" {
+		return nil, fmt.Errorf("templates are not loaded from files")
+	}
+
+	templates, err := app.LoadMessageTemplates(bot.reload.TemplatePath, bot.reload.TemplatesDir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Parsing does not find unknown fields and functions with wrong arguments
+	sample := bot.sampleTemplateData(time.Now())
+
+	for _, tpl := range templates {
+		if err := tpl.Template.Execute(io.Discard, sample); err != nil {
+			return nil, fmt.Errorf("%s: %w", tpl.Path, err)
+		}
+
+		for _, row := range tpl.Options.Keyboard {
+			for _, button := range row {
+				if _, _, err := button.Render(sample); err != nil {
+					return nil, fmt.Errorf("%s: keyboard button %q: %w", tpl.Path, button.Text, err)
+				}
+			}
+		}
+	}
+
+	bot.templates.set(templates)
+
+	return bot.templates.names(), nil
+}
+
+// watchTemplates : reload message templates when the files change
+func (bot *Bot) watchTemplates(stop <-chan struct{}) error {
+	if bot.reload.Interval <= 0 || bot.reload.TemplatePath == "" {
+		<-stop
+		return nil
+	}
+
+	ticker := time.NewTicker(bot.reload.Interval)
+	defer ticker.Stop()
+
+	state := templateFilesState(bot.reload.TemplatePath, bot.reload.TemplatesDir)
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+
+		if newState := templateFilesState(bot.reload.TemplatePath, bot.reload.TemplatesDir); newState != state {
+			state = newState
+			bot.ReloadTemplates("files changed")
+		}
+	}
+}
+
+// templateFilesState : size and modification time of the template files, it changes when the files change
+func templateFilesState(path string, dir string) string {
+	dirPaths, err := app.MessageTemplateFiles(dir)
+
+	if err != nil {
+		return err.Error()
+	}
+
+	var (
+		paths []string
+		state strings.Builder
+	)
+
+	// Sidecar options files are watched too, missing ones give the same state until they are created
+	for _, path := range append([]string{path}, dirPaths...) {
+		paths = append(paths, path, app.MessageTemplateOptionsFile(path))
+	}
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+
+		if err != nil {
+			fmt.Fprintf(&state, "%s %v\n", path, err)
+			continue
+		}
+
+		fmt.Fprintf(&state, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+	}
+
+	return state.String()
+}
+
+// handleReload : /reload, reload message templates
+func (bot *Bot) handleReload(m *telebot.Message) error {
+	// Failures are reported to admins like the ones of SIGHUP and file changes
+	err := bot.ReloadTemplates("command")
+	text := fmt.Sprintf("Templates are reloaded: %s", strings.Join(bot.templates.names(), ", "))
+
+	if err != nil {
+		text = fmt.Sprintf("Templates reload failed, the last good templates are kept\n%v", err)
+	}
+
+	_, err = bot.tb.Send(m.Chat, text, &telebot.SendOptions{ThreadID: m.ThreadID})
+
+	return err
+}
@@ -0,0 +1,60 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var timeArgLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseDuration : positive Go duration or number of days, e.g. 90m, 4h, 2d
+func parseDuration(s string) (time.Duration, error) {
+	var (
+		duration time.Duration
+		err      error
+	)
+
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		duration = time.Duration(n) * 24 * time.Hour
+	} else {
+		duration, err = time.ParseDuration(s)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	if duration <= 0 {
+		return 0, fmt.Errorf("duration %q is not positive", s)
+	}
+
+	return duration, nil
+}
+
+// parseTimeArg : "now", time ago as duration, e.g. 24h or 7d, or date in local time, e.g. 2024-05-24T15:04
+func parseTimeArg(s string, now time.Time) (time.Time, error) {
+	if s == "now" {
+		return now, nil
+	}
+
+	if duration, err := parseDuration(s); err == nil {
+		return now.Add(-duration), nil
+	}
+
+	for _, layout := range timeArgLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %q, use now, duration like 24h or 7d, or date like 2024-05-24T15:04", s)
+}
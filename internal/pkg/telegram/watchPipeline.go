@@ -0,0 +1,215 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/grafana"
+	"github.com/zt-sv/grafana-annotations-bot/internal/pkg/health"
+	"gopkg.in/telebot.v3"
+)
+
+const (
+	// Delivery error rate is not evaluated for fewer messages per interval
+	deliveryMinMessages = 5
+	storePingTimeout    = 10 * time.Second
+)
+
+// AlertingOptions : pipeline self-monitoring config
+type AlertingOptions struct {
+	// ChatID : chat for pipeline alerts, admins get them in private chats when 0
+	ChatID int64
+	// ScrapeFailures : consecutive scrape failures to alert on, 0 disables
+	ScrapeFailures int
+	// StoreFailures : consecutive store failures to alert on, 0 disables
+	StoreFailures int
+	// DeliveryErrorRate : share of failed messages per interval to alert on, 0 disables
+	DeliveryErrorRate float64
+	// Interval : how often the pipeline is checked
+	Interval time.Duration
+	// MinInterval : minimal interval between messages about the same problem
+	MinInterval time.Duration
+	// RepeatInterval : interval to repeat alert while the problem persists
+	RepeatInterval time.Duration
+}
+
+type deliveryStats struct {
+	sent   atomic.Int64
+	failed atomic.Int64
+}
+
+func (s *deliveryStats) record(err error) {
+	if err != nil {
+		s.failed.Add(1)
+		return
+	}
+	s.sent.Add(1)
+}
+
+func (s *deliveryStats) reset() (sent int64, failed int64) {
+	return s.sent.Swap(0), s.failed.Swap(0)
+}
+
+type pipelineProblem struct {
+	title    string
+	firing   bool
+	since    time.Time
+	notified bool
+	lastSent time.Time
+}
+
+func (bot *Bot) watchPipeline(stop <-chan struct{}) error {
+	if bot.alerting.Interval <= 0 {
+		<-stop
+		return nil
+	}
+
+	ticker := time.NewTicker(bot.alerting.Interval)
+	defer ticker.Stop()
+
+	scrapes := map[string]*pipelineProblem{}
+
+	for _, instance := range bot.grafana {
+		scrapes[instance.Name] = &pipelineProblem{title: "Grafana scrape"}
+
+		if len(bot.grafana) > 1 {
+			scrapes[instance.Name].title = fmt.Sprintf("Grafana %s scrape", instance.Name)
+		}
+	}
+
+	store := &pipelineProblem{title: "Store"}
+	// Ping failures are counted apart from failed operations, a successful ping does not reset them
+	storePing := health.NewDependency()
+	delivery := &pipelineProblem{title: "Telegram delivery"}
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+			for _, instance := range bot.grafana {
+				if bot.alerting.ScrapeFailures == 0 {
+					break
+				}
+
+				failures := instance.State.Failures()
+				lastErr := instance.State.LastError()
+				bot.updateProblem(
+					scrapes[instance.Name],
+					failures >= bot.alerting.ScrapeFailures,
+					fmt.Sprintf("%s\n%d consecutive failures, last error: %v", grafana.Describe(lastErr), failures, lastErr),
+				)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), storePingTimeout)
+
+			if err := bot.store.Ping(ctx); err != nil {
+				storePing.Fail(err)
+			} else {
+				storePing.Recover()
+			}
+
+			cancel()
+
+			if bot.alerting.StoreFailures > 0 {
+				failures, lastErr := bot.storeState.Failures(), bot.storeState.LastError()
+
+				if storePing.Failures() > failures {
+					failures, lastErr = storePing.Failures(), storePing.LastError()
+				}
+
+				bot.updateProblem(
+					store,
+					failures >= bot.alerting.StoreFailures,
+					fmt.Sprintf("%d consecutive failures, last error: %v", failures, lastErr),
+				)
+			}
+
+			sent, failed := bot.delivery.reset()
+
+			if bot.alerting.DeliveryErrorRate > 0 {
+				total := sent + failed
+				bot.updateProblem(
+					delivery,
+					total >= deliveryMinMessages && float64(failed)/float64(total) >= bot.alerting.DeliveryErrorRate,
+					fmt.Sprintf("%d of %d messages failed in the last %s", failed, total, bot.alerting.Interval),
+				)
+			}
+		}
+	}
+}
+
+func (bot *Bot) recordStoreResult(err error) {
+	if err != nil {
+		if bot.storeState.Fail(err) {
+			level.Warn(bot.logger).Log("msg", "store is unavailable", "err", err)
+		}
+		return
+	}
+
+	if bot.storeState.Recover() {
+		level.Info(bot.logger).Log("msg", "store is available again")
+	}
+}
+
+func (bot *Bot) updateProblem(problem *pipelineProblem, firing bool, details string) {
+	now := time.Now()
+
+	if firing && !problem.firing {
+		problem.firing = true
+		problem.since = now
+	}
+
+	if !firing && problem.firing && !problem.notified {
+		problem.firing = false
+		return
+	}
+
+	if now.Sub(problem.lastSent) < bot.alerting.MinInterval {
+		return
+	}
+
+	switch {
+	case firing && (!problem.notified || now.Sub(problem.lastSent) >= bot.alerting.RepeatInterval):
+		level.Warn(bot.logger).Log("msg", "pipeline is broken", "problem", problem.title, "details", details)
+		bot.notifyPipelineAdmins(fmt.Sprintf(
+			"⚠️ <b>%s is failing</b>\nSince: %s\n%s",
+			problem.title,
+			problem.since.Format(time.RFC1123),
+			html.EscapeString(details),
+		))
+		problem.notified = true
+		problem.lastSent = now
+
+	case !firing && problem.firing:
+		level.Info(bot.logger).Log("msg", "pipeline is recovered", "problem", problem.title)
+		bot.notifyPipelineAdmins(fmt.Sprintf(
+			"✅ <b>%s is recovered</b>\nFailed since: %s",
+			problem.title,
+			problem.since.Format(time.RFC1123),
+		))
+		problem.firing = false
+		problem.notified = false
+		problem.lastSent = now
+	}
+}
+
+func (bot *Bot) notifyPipelineAdmins(text string) {
+	recipients := []int64{bot.alerting.ChatID}
+
+	if bot.alerting.ChatID == 0 {
+		recipients = bot.admins
+	}
+
+	for _, id := range recipients {
+		_, err := bot.sendHTML(telebot.ChatID(id), text, &telebot.SendOptions{})
+
+		if err != nil {
+			level.Error(bot.logger).Log("msg", "failed to send pipeline alert", "chat", id, "err", err)
+		}
+	}
+}
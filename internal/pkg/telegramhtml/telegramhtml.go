@@ -0,0 +1,401 @@
+package telegramhtml
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// MaxMessageLength : Telegram limit of message text length after entities parsing, in UTF-16 code units
+const MaxMessageLength = 4096
+
+type tokenKind int
+
+const (
+	textToken tokenKind = iota
+	openToken
+	closeToken
+)
+
+// token : text or tag of sanitized message, text is unescaped
+type token struct {
+	kind tokenKind
+	name string
+	text string
+	html string
+}
+
+var (
+	tagRegexp     = regexp.MustCompile(`^<(/?)([a-zA-Z][a-zA-Z0-9-]*)((?:\s+[^\s=>/]+(?:\s*=\s*(?:"[^"]*"|'[^']*'|[^\s"'>]+))?)*)\s*/?>`)
+	attrRegexp    = regexp.MustCompile(`([^\s=>/]+)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+)))?`)
+	commentRegexp = regexp.MustCompile(`^<!--[\s\S]*?-->`)
+)
+
+// Tags supported by Telegram with the allowed attributes, see https://core.telegram.org/bots/api#html-style
+var allowedTags = map[string][]string{
+	"b":          nil,
+	"strong":     nil,
+	"i":          nil,
+	"em":         nil,
+	"u":          nil,
+	"ins":        nil,
+	"s":          nil,
+	"strike":     nil,
+	"del":        nil,
+	"tg-spoiler": nil,
+	"span":       {"class"},
+	"a":          {"href"},
+	"code":       {"class"},
+	"pre":        nil,
+	"blockquote": {"expandable"},
+	"tg-emoji":   {"emoji-id"},
+}
+
+// Unsupported block tags replaced by line breaks, closing ones or all
+var lineBreakTags = map[string]bool{
+	"br": true, "p": true, "div": true, "li": true, "tr": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+// Unsupported tags dropped with the content
+var skippedTags = map[string]bool{"script": true, "style": true, "head": true, "title": true}
+
+// Sanitize : keep tags and attributes supported by Telegram, fix nesting and escape the rest of the text
+func Sanitize(s string) string {
+	var b strings.Builder
+
+	for _, t := range sanitize(s) {
+		b.WriteString(t.html)
+	}
+
+	return b.String()
+}
+
+// PlainText : text of the message without tags
+func PlainText(s string) string {
+	var b strings.Builder
+
+	for _, t := range sanitize(s) {
+		b.WriteString(t.text)
+	}
+
+	return b.String()
+}
+
+// Length : length of the message text counted by Telegram
+func Length(s string) int {
+	return utf16Len(PlainText(s))
+}
+
+// Split : sanitize the message and split it into messages not longer than the limit
+//
+// Messages are split at paragraphs, lines or words when possible. Tags open at the split are closed
+// at the end of the message and opened again at the beginning of the next one.
+func Split(s string, limit int) []string {
+	var (
+		messages []string
+		stack    []token
+		b        strings.Builder
+		size     int
+	)
+
+	flush := func() {
+		for i := len(stack) - 1; i >= 0; i-- {
+			b.WriteString(closingTag(stack[i].name))
+		}
+
+		if message := b.String(); strings.TrimSpace(PlainText(message)) != "" {
+			messages = append(messages, message)
+		}
+
+		b.Reset()
+		size = 0
+
+		for _, t := range stack {
+			b.WriteString(t.html)
+		}
+	}
+
+	for _, t := range sanitize(s) {
+		switch t.kind {
+		case openToken:
+			stack = append(stack, t)
+			b.WriteString(t.html)
+
+		case closeToken:
+			stack = stack[:len(stack)-1]
+			b.WriteString(t.html)
+
+		case textToken:
+			text := t.text
+
+			for utf16Len(text) > limit-size {
+				n := splitPoint(text, limit-size, size == 0)
+
+				if n > 0 {
+					b.WriteString(escapeText(text[:n]))
+					text = text[n:]
+				}
+
+				flush()
+			}
+
+			b.WriteString(escapeText(text))
+			size += utf16Len(text)
+		}
+	}
+
+	flush()
+
+	return messages
+}
+
+// SplitText : split the plain text into messages not longer than the limit at paragraphs, lines or words
+// when possible
+func SplitText(text string, limit int) []string {
+	var messages []string
+
+	for utf16Len(text) > limit {
+		n := splitPoint(text, limit, true)
+
+		if message := text[:n]; strings.TrimSpace(message) != "" {
+			messages = append(messages, message)
+		}
+
+		text = text[n:]
+	}
+
+	if strings.TrimSpace(text) != "" {
+		messages = append(messages, text)
+	}
+
+	return messages
+}
+
+// splitPoint : byte index to split the text at, 0 to split before the text
+func splitPoint(text string, budget int, force bool) int {
+	end, size := 0, 0
+
+	for i, r := range text {
+		if size+UTF16RuneLen(r) > budget {
+			break
+		}
+
+		size += UTF16RuneLen(r)
+		end = i + utf8.RuneLen(r)
+	}
+
+	for _, separator := range []string{"\n\n", "\n", " "} {
+		if i := strings.LastIndex(text[:end], separator); i > 0 {
+			return i + len(separator)
+		}
+	}
+
+	if !force {
+		return 0
+	}
+
+	if end == 0 {
+		_, end = utf8.DecodeRuneInString(text)
+	}
+
+	return end
+}
+
+// sanitize : tokens of the message with supported tags only, every open tag is closed
+func sanitize(s string) []token {
+	var (
+		tokens []token
+		stack  []string
+		text   strings.Builder
+	)
+
+	flushText := func() {
+		if text.Len() > 0 {
+			value := html.UnescapeString(text.String())
+			tokens = append(tokens, token{kind: textToken, text: value, html: escapeText(value)})
+			text.Reset()
+		}
+	}
+
+	lineBreak := func() {
+		text.WriteString("\n")
+	}
+
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '<')
+
+		if i < 0 {
+			text.WriteString(s)
+			break
+		}
+
+		text.WriteString(s[:i])
+		s = s[i:]
+
+		if comment := commentRegexp.FindString(s); comment != "" {
+			s = s[len(comment):]
+			continue
+		}
+
+		match := tagRegexp.FindStringSubmatch(s)
+
+		if match == nil {
+			text.WriteString("&lt;")
+			s = s[1:]
+			continue
+		}
+
+		s = s[len(match[0]):]
+		closing, name, attrs := match[1] == "/", strings.ToLower(match[2]), match[3]
+		allowed, ok := allowedTags[name]
+
+		if !ok {
+			if skippedTags[name] && !closing {
+				// Drop the content with the tag
+				if end := strings.Index(strings.ToLower(s), closingTag(name)); end >= 0 {
+					s = s[end+len(closingTag(name)):]
+				} else {
+					s = ""
+				}
+
+				continue
+			}
+
+			if lineBreakTags[name] && (closing || name == "br") {
+				lineBreak()
+			}
+
+			continue
+		}
+
+		if closing {
+			depth := indexOf(stack, name)
+
+			if depth < 0 {
+				continue
+			}
+
+			flushText()
+
+			for len(stack) > depth {
+				tokens = append(tokens, token{kind: closeToken, name: stack[len(stack)-1], html: closingTag(stack[len(stack)-1])})
+				stack = stack[:len(stack)-1]
+			}
+
+			continue
+		}
+
+		if !canOpen(stack, name) {
+			continue
+		}
+
+		tag, ok := openingTag(name, allowed, attrs)
+
+		if !ok {
+			continue
+		}
+
+		flushText()
+		tokens = append(tokens, token{kind: openToken, name: name, html: tag})
+		stack = append(stack, name)
+	}
+
+	flushText()
+
+	for i := len(stack) - 1; i >= 0; i-- {
+		tokens = append(tokens, token{kind: closeToken, name: stack[i], html: closingTag(stack[i])})
+	}
+
+	return tokens
+}
+
+// canOpen : Telegram does not allow tags in code blocks, except code in pre, and links in links
+func canOpen(stack []string, name string) bool {
+	if len(stack) > 0 && stack[len(stack)-1] == "pre" {
+		return name == "code"
+	}
+
+	if indexOf(stack, "pre") >= 0 || indexOf(stack, "code") >= 0 {
+		return false
+	}
+
+	return name != "a" || indexOf(stack, "a") < 0
+}
+
+// openingTag : tag with allowed attributes, false when the tag is not supported with the attributes
+func openingTag(name string, allowed []string, attrs string) (string, bool) {
+	var b strings.Builder
+
+	b.WriteString("<" + name)
+
+	for _, attr := range attrRegexp.FindAllStringSubmatch(attrs, -1) {
+		key := strings.ToLower(attr[1])
+
+		if indexOf(allowed, key) < 0 {
+			continue
+		}
+
+		value := html.UnescapeString(attr[2] + attr[3] + attr[4])
+
+		switch {
+		case name == "span" && value != "tg-spoiler":
+			return "", false
+		case name == "blockquote":
+			b.WriteString(" " + key)
+			continue
+		}
+
+		b.WriteString(" " + key + `="` + html.EscapeString(value) + `"`)
+	}
+
+	b.WriteString(">")
+
+	tag := b.String()
+
+	// Telegram requires the attributes of these tags
+	if (name == "span" || name == "a" || name == "tg-emoji") && !strings.Contains(tag, "=") {
+		return "", false
+	}
+
+	return tag, true
+}
+
+func closingTag(name string) string {
+	return "</" + name + ">"
+}
+
+func escapeText(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+func utf16Len(s string) int {
+	n := 0
+
+	for _, r := range s {
+		n += UTF16RuneLen(r)
+	}
+
+	return n
+}
+
+// UTF16RuneLen : length of the character in UTF-16 code units, Telegram counts message length in them
+func UTF16RuneLen(r rune) int {
+	if utf16.IsSurrogate(r) || r < 0x10000 {
+		return 1
+	}
+
+	return 2
+}
+
+func indexOf(list []string, value string) int {
+	for i, v := range list {
+		if v == value {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,237 @@
+package telegramhtml
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain text", "Deployed v1.2.3", "Deployed v1.2.3"},
+		{"allowed tags", "<b>a</b> <i>b</i> <u>c</u> <s>d</s> <tg-spoiler>e</tg-spoiler>", "<b>a</b> <i>b</i> <u>c</u> <s>d</s> <tg-spoiler>e</tg-spoiler>"},
+		{"upper case tags", "<B>a</B>", "<b>a</b>"},
+		{"crossed tags", "<b>bold <i>italic</b> rest</i>", "<b>bold <i>italic</i></b> rest"},
+		{"unclosed tags", "<b>bold <i>italic", "<b>bold <i>italic</i></b>"},
+		{"closing tag without opening", "text</b>", "text"},
+		{"unknown tags", "<x-custom>a</x-custom> <font color=red>b</font>", "a b"},
+		{"line break tags", "a<br>b<br/>c<p>d</p><div>e</div><li>f</li><h1>g</h1>", "a\nb\ncd\ne\nf\ng\n"},
+		{"comments", "a<!-- <b>hidden</b> -->b", "ab"},
+		{"script", `a<script>alert("<b>")</script>b<STYLE>p { color: red }</style>c`, "abc"},
+		{"unclosed script", "a<script>alert(1)", "a"},
+		{"link", `<a href="https://grafana/d/x?a=1&amp;b=2" onclick="x()">link</a>`, `<a href="https://grafana/d/x?a=1&amp;b=2">link</a>`},
+		{"link without href", `<a name="x">link</a>`, "link"},
+		{"nested links", `<a href="https://a">a <a href="https://b">b</a></a>`, `<a href="https://a">a b</a>`},
+		{"spoiler span", `<span class="tg-spoiler">s</span> <span class="red">r</span> <span>n</span>`, `<span class="tg-spoiler">s</span> r n`},
+		{"single quoted attribute", `<a href='https://a'>a</a>`, `<a href="https://a">a</a>`},
+		{"unquoted attribute", `<a href=https://a>a</a>`, `<a href="https://a">a</a>`},
+		{"code language", `<pre><code class="language-go">x</code></pre>`, `<pre><code class="language-go">x</code></pre>`},
+		{"tags in code", "<code><b>x</b> <i>y</i></code>", "<code>x y</code>"},
+		{"tags in pre", "<pre><b>x</b></pre>", "<pre>x</pre>"},
+		{"expandable blockquote", `<blockquote expandable>q</blockquote> <blockquote class="x">r</blockquote>`, "<blockquote expandable>q</blockquote> <blockquote>r</blockquote>"},
+		{"custom emoji", `<tg-emoji emoji-id="5368324170671202286">👍</tg-emoji>`, `<tg-emoji emoji-id="5368324170671202286">👍</tg-emoji>`},
+		{"escaping", "1 < 2 && 3 > 2", "1 &lt; 2 &amp;&amp; 3 &gt; 2"},
+		{"entities", "&lt;b&gt; &amp;lt; &quot;q&quot; &#39;", `&lt;b&gt; &amp;lt; "q" '`},
+		{"attribute escaping", `<a href="https://a/?q=&quot;x&quot;&lt;">a</a>`, `<a href="https://a/?q=&#34;x&#34;&lt;">a</a>`},
+		{"bare less than sign", "a <3 b", "a &lt;3 b"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Sanitize(test.in); got != test.want {
+				t.Errorf("Sanitize(%q) = %q, want %q", test.in, got, test.want)
+			}
+		})
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"<b>bold</b> &amp; <i>italic</i>", "bold & italic"},
+		{`<a href="https://a">link</a><br>next`, "link\nnext"},
+		{"1 < 2", "1 < 2"},
+		{"<script>x</script>text", "text"},
+	}
+
+	for _, test := range tests {
+		if got := PlainText(test.in); got != test.want {
+			t.Errorf("PlainText(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestLength(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{"abc", 3},
+		{"<b>abc</b>", 3},
+		{"&amp;", 1},
+		{"привет", 6},
+		// Characters outside the Basic Multilingual Plane are surrogate pairs in UTF-16
+		{"😀", 2},
+		{"<i>a😀b</i>", 4},
+	}
+
+	for _, test := range tests {
+		if got := Length(test.in); got != test.want {
+			t.Errorf("Length(%q) = %d, want %d", test.in, got, test.want)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		limit int
+		want  []string
+	}{
+		{
+			name:  "under the limit",
+			in:    "<b>short</b> message",
+			limit: 100,
+			want:  []string{"<b>short</b> message"},
+		},
+		{
+			name:  "exactly the limit",
+			in:    "<b>12345</b>",
+			limit: 5,
+			want:  []string{"<b>12345</b>"},
+		},
+		{
+			name:  "paragraphs",
+			in:    "first paragraph\n\nsecond paragraph",
+			limit: 20,
+			want:  []string{"first paragraph\n\n", "second paragraph"},
+		},
+		{
+			name:  "lines before words",
+			in:    "one two\nthree four",
+			limit: 14,
+			want:  []string{"one two\n", "three four"},
+		},
+		{
+			name:  "words",
+			in:    "one two three four",
+			limit: 9,
+			want:  []string{"one two ", "three ", "four"},
+		},
+		{
+			name:  "long word",
+			in:    "abcdefghij",
+			limit: 4,
+			want:  []string{"abcd", "efgh", "ij"},
+		},
+		{
+			name:  "tags reopened",
+			in:    `<b>bold <a href="https://a">link text</a></b> tail`,
+			limit: 10,
+			want: []string{
+				`<b>bold <a href="https://a">link </a></b>`,
+				`<b><a href="https://a">text</a></b> tail`,
+			},
+		},
+		{
+			name:  "pre block",
+			in:    "<pre>line 1\nline 2\nline 3</pre>",
+			limit: 14,
+			want:  []string{"<pre>line 1\nline 2\n</pre>", "<pre>line 3</pre>"},
+		},
+		{
+			name:  "escaped text is counted unescaped",
+			in:    "a &amp; b &lt; c",
+			limit: 5,
+			want:  []string{"a &amp; ", "b &lt; c"},
+		},
+		{
+			name:  "surrogate pairs are not split",
+			in:    "😀😀😀",
+			limit: 3,
+			want:  []string{"😀", "😀", "😀"},
+		},
+		{
+			name:  "surrogate pairs fill the limit",
+			in:    "😀😀😀😀😀",
+			limit: 4,
+			want:  []string{"😀😀", "😀😀", "😀"},
+		},
+		{
+			name:  "empty parts are dropped",
+			in:    "<b></b>   ",
+			limit: 10,
+			want:  nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Split(test.in, test.limit)
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("Split(%q, %d) = %q, want %q", test.in, test.limit, got, test.want)
+			}
+
+			for _, part := range got {
+				if Length(part) > test.limit {
+					t.Errorf("part %q is longer than %d", part, test.limit)
+				}
+
+				if Sanitize(part) != part {
+					t.Errorf("part %q is not balanced", part)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitKeepsText(t *testing.T) {
+	in := "<b>" + strings.Repeat("word ", 2000) + "</b>\n\n<i>" + strings.Repeat("😀 ", 1000) + "</i>"
+	parts := Split(in, MaxMessageLength)
+
+	if len(parts) < 2 {
+		t.Fatalf("got %d parts, want several", len(parts))
+	}
+
+	var text strings.Builder
+
+	for _, part := range parts {
+		if Length(part) > MaxMessageLength {
+			t.Errorf("part is %d long", Length(part))
+		}
+
+		text.WriteString(PlainText(part))
+	}
+
+	if text.String() != PlainText(in) {
+		t.Error("text of the parts differs from the message text")
+	}
+}
+
+func TestSplitText(t *testing.T) {
+	tests := []struct {
+		in    string
+		limit int
+		want  []string
+	}{
+		{"short", 10, []string{"short"}},
+		{"one two three", 8, []string{"one two ", "three"}},
+		{"a\n\nb c", 4, []string{"a\n\n", "b c"}},
+		{"😀😀😀", 4, []string{"😀😀", "😀"}},
+		{"   ", 10, nil},
+	}
+
+	for _, test := range tests {
+		if got := SplitText(test.in, test.limit); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("SplitText(%q, %d) = %q, want %q", test.in, test.limit, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package templatefuncs
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Characters escaped in Telegram MarkdownV2, see https://core.telegram.org/bots/api#markdownv2-style
+const markdownV2Special = "\\_*[]()~`>#+-=|{}.!"
+
+// Emoji of annotation states, Grafana alert states and Alertmanager statuses
+var stateEmojis = map[string]string{
+	"alerting": "🔴",
+	"firing":   "🔴",
+	"pending":  "🟡",
+	"ok":       "✅",
+	"normal":   "✅",
+	"resolved": "✅",
+	"no_data":  "❔",
+	"nodata":   "❔",
+	"paused":   "⏸",
+	"error":    "⚠️",
+}
+
+const defaultStateEmoji = "🔔"
+
+var regexps sync.Map
+
+// FuncMap : functions available in templates
+//
+// Functions take the piped value as the last argument, e.g. {{.Text | truncate 100}}.
+func FuncMap() template.FuncMap {
+	return template.FuncMap{
+		// Strings
+		"truncate":     truncate,
+		"replace":      replace,
+		"regexMatch":   regexMatch,
+		"regexFind":    regexFind,
+		"regexReplace": regexReplace,
+		"upper":        strings.ToUpper,
+		"lower":        strings.ToLower,
+		"trim":         strings.TrimSpace,
+		"contains":     contains,
+		"hasPrefix":    hasPrefix,
+		"hasSuffix":    hasSuffix,
+		"split":        split,
+		"join":         join,
+		"default":      defaultValue,
+
+		// Time
+		"now":              now,
+		"toTime":           toTime,
+		"formatTime":       formatTime,
+		"formatTimeIn":     formatTimeIn,
+		"humanizeDuration": humanizeDuration,
+		"relativeTime":     relativeTime,
+
+		// Escaping
+		"escapeHTML":       escapeHTML,
+		"escapeMarkdownV2": escapeMarkdownV2,
+		"safeHTML":         safeHTML,
+
+		// Tags
+		"hasTag":   hasTag,
+		"tagValue": tagValue,
+
+		// States
+		"stateEmoji": stateEmoji,
+	}
+}
+
+// truncate : cut the string to n characters, the cut string ends with ellipsis
+func truncate(n int, s string) string {
+	if n <= 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+
+	runes := []rune(s)
+
+	return string(runes[:n-1]) + "…"
+}
+
+func replace(old string, new string, s string) string {
+	return strings.ReplaceAll(s, old, new)
+}
+
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexps.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile(pattern)
+
+	if err != nil {
+		return nil, err
+	}
+
+	regexps.Store(pattern, re)
+
+	return re, nil
+}
+
+func regexMatch(pattern string, s string) (bool, error) {
+	re, err := compileRegexp(pattern)
+
+	if err != nil {
+		return false, err
+	}
+
+	return re.MatchString(s), nil
+}
+
+// regexFind : the first match of the pattern, or of its first group when the pattern has groups
+func regexFind(pattern string, s string) (string, error) {
+	re, err := compileRegexp(pattern)
+
+	if err != nil {
+		return "", err
+	}
+
+	match := re.FindStringSubmatch(s)
+
+	switch {
+	case match == nil:
+		return "", nil
+	case len(match) > 1:
+		return match[1], nil
+	default:
+		return match[0], nil
+	}
+}
+
+// regexReplace : replace matches of the pattern, the replacement may refer groups as $1
+func regexReplace(pattern string, replacement string, s string) (string, error) {
+	re, err := compileRegexp(pattern)
+
+	if err != nil {
+		return "", err
+	}
+
+	return re.ReplaceAllString(s, replacement), nil
+}
+
+func contains(substr string, s string) bool {
+	return strings.Contains(s, substr)
+}
+
+func hasPrefix(prefix string, s string) bool {
+	return strings.HasPrefix(s, prefix)
+}
+
+func hasSuffix(suffix string, s string) bool {
+	return strings.HasSuffix(s, suffix)
+}
+
+func split(sep string, s string) []string {
+	return strings.Split(s, sep)
+}
+
+func join(sep string, elems []string) string {
+	return strings.Join(elems, sep)
+}
+
+// defaultValue : the value, or the default one when the value is empty
+func defaultValue(def string, value string) string {
+	if value == "" {
+		return def
+	}
+
+	return value
+}
+
+// escapeHTML : escape the string for Telegram HTML, html/template does not escape it again
+func escapeHTML(s string) template.HTML {
+	return template.HTML(html.EscapeString(s))
+}
+
+// safeHTML : insert the string as is, e.g. annotation text with Telegram HTML tags
+func safeHTML(s string) template.HTML {
+	return template.HTML(s)
+}
+
+// escapeMarkdownV2 : escape the string for Telegram MarkdownV2
+func escapeMarkdownV2(s string) string {
+	var escaped strings.Builder
+
+	for _, r := range s {
+		if strings.ContainsRune(markdownV2Special, r) {
+			escaped.WriteRune('\\')
+		}
+
+		escaped.WriteRune(r)
+	}
+
+	return escaped.String()
+}
+
+func hasTag(tag string, tags []string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tagValue : value of the first "key:value" tag with the key
+func tagValue(key string, tags []string) string {
+	for _, tag := range tags {
+		if value, found := strings.CutPrefix(tag, key+":"); found {
+			return strings.TrimSpace(value)
+		}
+	}
+
+	return ""
+}
+
+// stateEmoji : emoji of the alert state, 🔔 for unknown states
+func stateEmoji(state string) string {
+	if emoji, ok := stateEmojis[strings.ToLower(state)]; ok {
+		return emoji
+	}
+
+	return defaultStateEmoji
+}
@@ -0,0 +1,180 @@
+package templatefuncs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		in   string
+		want string
+	}{
+		{"shorter", 10, "deploy", "deploy"},
+		{"exact", 6, "deploy", "deploy"},
+		{"longer", 4, "deploy", "dep…"},
+		{"one character", 1, "deploy", "…"},
+		{"zero", 0, "deploy", "deploy"},
+		{"multibyte", 3, "привет", "пр…"},
+		{"emoji", 2, "🔴🟡✅", "🔴…"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := truncate(test.n, test.in); got != test.want {
+				t.Errorf("truncate(%d, %q) = %q, want %q", test.n, test.in, got, test.want)
+			}
+		})
+	}
+}
+
+func TestHumanize(t *testing.T) {
+	tests := []struct {
+		in   time.Duration
+		want string
+	}{
+		{0, "0s"},
+		{999 * time.Millisecond, "0s"},
+		{time.Second, "1s"},
+		{-90 * time.Second, "1m 30s"},
+		{time.Hour, "1h"},
+		{time.Hour + 59*time.Second, "1h 59s"},
+		{24 * time.Hour, "1d"},
+		{24*time.Hour + 5*time.Minute, "1d 5m"},
+		{50*time.Hour + 30*time.Minute + 10*time.Second, "2d 2h"},
+	}
+
+	for _, test := range tests {
+		if got := humanize(test.in); got != test.want {
+			t.Errorf("humanize(%v) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestHumanizeDuration(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{90, "1m 30s"},
+		{int64(3600), "1h"},
+		{1.5, "1s"},
+		{"36h", "1d 12h"},
+		{2 * time.Minute, "2m"},
+	}
+
+	for _, test := range tests {
+		got, err := humanizeDuration(test.in)
+
+		if err != nil || got != test.want {
+			t.Errorf("humanizeDuration(%#v) = %q, %v, want %q", test.in, got, err, test.want)
+		}
+	}
+
+	if _, err := humanizeDuration(true); err == nil {
+		t.Error("humanizeDuration(true): no error")
+	}
+}
+
+func TestRelativeTime(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		in   time.Time
+		want string
+	}{
+		{now, "now"},
+		{now.Add(-5*time.Minute - time.Second), "5m 1s ago"},
+		{now.Add(2*time.Hour + 500*time.Millisecond), "in 2h"},
+		{now.Add(-25 * time.Hour), "1d 1h ago"},
+	}
+
+	for _, test := range tests {
+		got, err := relativeTime(test.in)
+
+		if err != nil || got != test.want {
+			t.Errorf("relativeTime(%v) = %q, %v, want %q", test.in.Sub(now), got, err, test.want)
+		}
+	}
+}
+
+func TestToTime(t *testing.T) {
+	want := time.Date(2024, 5, 24, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		in   interface{}
+	}{
+		{"time", want},
+		{"int", int(want.UnixMilli())},
+		{"int64", want.UnixMilli()},
+		{"milliseconds string", "1716544800000"},
+		{"RFC 3339 string", "2024-05-24T10:00:00Z"},
+		{"RFC 3339 string with zone", "2024-05-24T12:00:00+02:00"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := toTime(test.in)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !got.Equal(want) {
+				t.Errorf("toTime(%#v) = %v, want %v", test.in, got, want)
+			}
+		})
+	}
+
+	for _, in := range []interface{}{"yesterday", 1.5, nil} {
+		if _, err := toTime(in); err == nil {
+			t.Errorf("toTime(%#v): no error", in)
+		}
+	}
+}
+
+func TestEscapeMarkdownV2(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"plain text", "plain text"},
+		{"v1.2.3", `v1\.2\.3`},
+		{"*bold* _italic_ ~strike~ ||spoiler||", `\*bold\* \_italic\_ \~strike\~ \|\|spoiler\|\|`},
+		{"[link](https://a)", `\[link\]\(https://a\)`},
+		{"`code` > quote # + - = { } !", "\\`code\\` \\> quote \\# \\+ \\- \\= \\{ \\} \\!"},
+		{`back\slash`, `back\\slash`},
+		{"привет 🔴", "привет 🔴"},
+	}
+
+	for _, test := range tests {
+		if got := escapeMarkdownV2(test.in); got != test.want {
+			t.Errorf("escapeMarkdownV2(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestTagValue(t *testing.T) {
+	tags := []string{"deploy", "env:prod", "env:staging", "version: 1.2.3", "url:https://a:8080"}
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"env", "prod"},
+		{"version", "1.2.3"},
+		{"url", "https://a:8080"},
+		{"deploy", ""},
+		{"missing", ""},
+		{"en", ""},
+	}
+
+	for _, test := range tests {
+		if got := tagValue(test.key, tags); got != test.want {
+			t.Errorf("tagValue(%q) = %q, want %q", test.key, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package templatefuncs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func now() time.Time {
+	return time.Now()
+}
+
+// toTime : convert time, Unix milliseconds like annotation timestamps or RFC 3339 string to time
+func toTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case int64:
+		return time.UnixMilli(t), nil
+	case int:
+		return time.UnixMilli(int64(t)), nil
+	case string:
+		if ms, err := strconv.ParseInt(t, 10, 64); err == nil {
+			return time.UnixMilli(ms), nil
+		}
+
+		return time.Parse(time.RFC3339, t)
+	default:
+		return time.Time{}, fmt.Errorf("can't convert %T to time", v)
+	}
+}
+
+// formatTime : format time with Go layout in local time of the bot
+func formatTime(layout string, v interface{}) (string, error) {
+	t, err := toTime(v)
+
+	if err != nil {
+		return "", err
+	}
+
+	return t.Local().Format(layout), nil
+}
+
+// formatTimeIn : format time with Go layout in the IANA time zone, e.g. "Europe/Berlin"
+func formatTimeIn(layout string, timezone string, v interface{}) (string, error) {
+	t, err := toTime(v)
+
+	if err != nil {
+		return "", err
+	}
+
+	location, err := time.LoadLocation(timezone)
+
+	if err != nil {
+		return "", err
+	}
+
+	return t.In(location).Format(layout), nil
+}
+
+// toDuration : convert duration, seconds or Go duration string to duration
+func toDuration(v interface{}) (time.Duration, error) {
+	switch d := v.(type) {
+	case time.Duration:
+		return d, nil
+	case int:
+		return time.Duration(d) * time.Second, nil
+	case int64:
+		return time.Duration(d) * time.Second, nil
+	case float64:
+		return time.Duration(d * float64(time.Second)), nil
+	case string:
+		return time.ParseDuration(d)
+	default:
+		return 0, fmt.Errorf("can't convert %T to duration", v)
+	}
+}
+
+// humanizeDuration : format duration with two largest non-zero units, e.g. "2d 3h", "1d 5m" or "5m 10s"
+func humanizeDuration(v interface{}) (string, error) {
+	d, err := toDuration(v)
+
+	if err != nil {
+		return "", err
+	}
+
+	return humanize(d), nil
+}
+
+func humanize(d time.Duration) string {
+	if d < 0 {
+		d = -d
+	}
+
+	if d < time.Second {
+		return "0s"
+	}
+
+	units := []struct {
+		name string
+		size time.Duration
+	}{
+		{"d", 24 * time.Hour},
+		{"h", time.Hour},
+		{"m", time.Minute},
+		{"s", time.Second},
+	}
+
+	var parts []string
+
+	for _, unit := range units {
+		// Zero units are skipped, e.g. "1d 5m" rather than "1d 0h"
+		if d >= unit.size {
+			parts = append(parts, fmt.Sprintf("%d%s", d/unit.size, unit.name))
+			d %= unit.size
+		}
+
+		if len(parts) == 2 {
+			break
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// relativeTime : time relative to now, e.g. "5m ago" or "in 2h"
+func relativeTime(v interface{}) (string, error) {
+	t, err := toTime(v)
+
+	if err != nil {
+		return "", err
+	}
+
+	d := time.Until(t)
+
+	switch {
+	case d > -time.Second && d < time.Second:
+		return "now", nil
+	case d > 0:
+		return "in " + humanize(d), nil
+	default:
+		return humanize(d) + " ago", nil
+	}
+}
@@ -0,0 +1,119 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// Config : TLS client options
+type Config struct {
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+}
+
+// New : create TLS client config
+//
+// The client certificate is reloaded when the cert or key file changes, so
+// rotated certificates are picked up without restart.
+func New(config Config, logger log.Logger) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+
+	if config.CAFile != "" {
+		caCert, err := os.ReadFile(config.CAFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("could not load ca certificate: %w", err)
+		}
+
+		caCertPool := x509.NewCertPool()
+
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in %s", config.CAFile)
+		}
+
+		tlsConfig.RootCAs = caCertPool
+	}
+
+	if config.CertFile != "" || config.KeyFile != "" {
+		reloader := &certReloader{
+			certFile: config.CertFile,
+			keyFile:  config.KeyFile,
+			logger:   logger,
+		}
+
+		if err := reloader.load(); err != nil {
+			return nil, fmt.Errorf("could not load certificates: %w", err)
+		}
+
+		tlsConfig.GetClientCertificate = reloader.getClientCertificate
+	}
+
+	return tlsConfig, nil
+}
+
+type certReloader struct {
+	mu          sync.Mutex
+	certFile    string
+	keyFile     string
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+	logger      log.Logger
+}
+
+func (r *certReloader) load() error {
+	certInfo, err := os.Stat(r.certFile)
+
+	if err != nil {
+		return err
+	}
+
+	keyInfo, err := os.Stat(r.keyFile)
+
+	if err != nil {
+		return err
+	}
+
+	if r.cert != nil && certInfo.ModTime().Equal(r.certModTime) && keyInfo.ModTime().Equal(r.keyModTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+
+	if err != nil {
+		return err
+	}
+
+	if r.cert != nil {
+		level.Info(r.logger).Log("msg", "client certificate reloaded", "cert", r.certFile)
+	}
+
+	r.cert = &cert
+	r.certModTime = certInfo.ModTime()
+	r.keyModTime = keyInfo.ModTime()
+
+	return nil
+}
+
+func (r *certReloader) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Keep the previous certificate while the new one is being written
+	if err := r.load(); err != nil {
+		level.Error(r.logger).Log("msg", "failed to reload client certificate, use the previous one", "err", err)
+	}
+
+	return r.cert, nil
+}